@@ -0,0 +1,50 @@
+// Command spotifymock serves a mock of the Spotify Web API and accounts service
+// with the data of a fixture file, see spotifytest.Fixture. Point recommendli at
+// it with SPOTIFY_API_URL=http://<addr>/v1/ and SPOTIFY_ACCOUNTS_URL=http://<addr>/.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations/spotifytest"
+	"github.com/kristofferostlund/recommendli/pkg/slogutil"
+)
+
+func main() {
+	var (
+		fixturePath  = flag.String("fixture", "", "path to a JSON fixture to serve, serves no data when empty")
+		addr         = flag.String("addr", "127.0.0.1:9998", "address to listen on")
+		throttleRate = flag.Float64("throttle-rate", 0, "share of requests to respond to with 429 Too Many Requests")
+		retryAfter   = flag.Duration("retry-after", time.Second, "Retry-After sent with 429 responses")
+		errorRate    = flag.Float64("error-rate", 0, "share of requests to respond to with 503 Service Unavailable")
+		logLevel     = flag.String("log-level", "info", "log level")
+	)
+	flag.Parse()
+
+	slogutil.InitDefaultLogger(*logLevel)
+
+	provider := spotifytest.New()
+	if *fixturePath != "" {
+		var err error
+		provider, err = spotifytest.LoadFixture(*fixturePath)
+		if err != nil {
+			slogutil.Fatal("Could not load fixture", slogutil.Error(err))
+		}
+	}
+
+	server := spotifytest.NewServer(provider)
+	if *throttleRate > 0 {
+		server.InjectFault(spotifytest.Fault{Path: "/v1/", Status: http.StatusTooManyRequests, RetryAfter: *retryAfter, Probability: *throttleRate})
+	}
+	if *errorRate > 0 {
+		server.InjectFault(spotifytest.Fault{Path: "/v1/", Status: http.StatusServiceUnavailable, Probability: *errorRate})
+	}
+
+	slog.Info("Serving mock Spotify", slog.String("addr", *addr), slog.String("api_url", "http://"+*addr+"/v1/"), slog.String("accounts_url", "http://"+*addr+"/"))
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		slogutil.Fatal("Server stopped", slogutil.Error(err))
+	}
+}
@@ -3,6 +3,7 @@ import { toast } from 'sonner'
 import { useGenerateDiscoveryPlaylist } from '@/shared/api/queries'
 import { GenerateButton } from './GenerateButton'
 import { TrackTable } from './TrackTable'
+import { DiscoveryProgress } from './DiscoveryProgress'
 import type { Playlist } from '@/shared/types/spotify'
 
 /**
@@ -39,6 +40,9 @@ export function DiscoveryPanel() {
         onGenerate={handleGenerate}
         isLoading={generateMutation.isPending}
       />
+      {generateMutation.isPending && generateMutation.progress && (
+        <DiscoveryProgress progress={generateMutation.progress} />
+      )}
       {playlist && <TrackTable tracks={playlist.tracks} />}
     </article>
   )
@@ -0,0 +1,46 @@
+import type { Progress } from '@/shared/types/spotify'
+
+const stageNames: Record<string, string> = {
+  queued: 'Queued',
+  syncing_index: 'Syncing track index',
+  populating_playlists: 'Fetching playlists',
+  fetching_playlist_tracks: 'Fetching playlist tracks',
+  scoring: 'Scoring tracks',
+  writing_playlist: 'Writing playlist',
+  done: 'Done',
+  failed: 'Failed',
+}
+
+interface DiscoveryProgressProps {
+  progress: Progress
+}
+
+/**
+ * Shows how far along the generation is, the bar is indeterminate while the
+ * size of the stage isn't known
+ */
+export function DiscoveryProgress({ progress }: DiscoveryProgressProps) {
+  const stage = stageNames[progress.stage] ?? progress.stage
+  const known = progress.total > 0
+
+  return (
+    <div className="mt-4 space-y-2" role="status" aria-live="polite">
+      <div className="flex items-center justify-between gap-4 text-sm opacity-70">
+        <span className="truncate">
+          {stage}
+          {progress.detail ? `: ${progress.detail}` : ''}
+        </span>
+        {known && (
+          <span className="font-mono text-xs flex-shrink-0">
+            {progress.current}/{progress.total}
+          </span>
+        )}
+      </div>
+      <progress
+        className="w-full h-2 accent-purple-600"
+        value={known ? progress.current : undefined}
+        max={known ? progress.total : undefined}
+      />
+    </div>
+  )
+}
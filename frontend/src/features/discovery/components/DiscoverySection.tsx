@@ -3,6 +3,7 @@ import { toast } from 'sonner'
 import { useGenerateDiscoveryPlaylist } from '@/shared/api/queries'
 import { SpotifyLink } from '@/shared/components/SpotifyLink'
 import { ArtistLinks } from '@/shared/components/ArtistLinks'
+import { DiscoveryProgress } from './DiscoveryProgress'
 import type { Playlist } from '@/shared/types/spotify'
 
 export function DiscoverySection() {
@@ -60,6 +61,12 @@ export function DiscoverySection() {
           </button>
         </div>
 
+        {generateMutation.isPending && generateMutation.progress && (
+          <div className="mb-6 md:mb-8 text-white">
+            <DiscoveryProgress progress={generateMutation.progress} />
+          </div>
+        )}
+
         {/* Track Grid */}
         {playlist && playlist.tracks.length > 0 && (
           <div className="space-y-4">
@@ -1,6 +1,11 @@
-import type { User, CurrentTrackResponse, Playlist, CheckTrackResponse, IndexSummary } from '@/shared/types/spotify'
+import type { User, CurrentTrackResponse, Playlist, CheckTrackResponse, IndexSummary, DiscoveryJob, Progress } from '@/shared/types/spotify'
 
 const BASE_URL = '/recommendations/v1'
+const JOB_POLL_INTERVAL = 2000
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms))
+
+const isFinished = (job: DiscoveryJob) => job.state === 'done' || job.state === 'failed'
 
 /**
  * Fetch wrapper with error handling
@@ -21,6 +26,61 @@ async function fetchAPI(url: string, init?: RequestInit): Promise<Response> {
   return response
 }
 
+async function pollDiscoveryJob(job: DiscoveryJob): Promise<DiscoveryJob> {
+  while (!isFinished(job)) {
+    await sleep(JOB_POLL_INTERVAL)
+    job = await api.getDiscoveryJob(job.id)
+  }
+  return job
+}
+
+/**
+ * Follows the job's progress events until it's finished, falling back to polling
+ * the job if the events can't be streamed.
+ */
+function followDiscoveryJob(job: DiscoveryJob, onProgress: (progress: Progress) => void): Promise<DiscoveryJob> {
+  if (typeof EventSource === 'undefined') {
+    return pollDiscoveryJob(job)
+  }
+
+  return new Promise((resolve, reject) => {
+    const events = new EventSource(`${BASE_URL}/events?job=${encodeURIComponent(job.id)}`)
+    let done = false
+    const finish = (promise: Promise<DiscoveryJob>) => {
+      if (done) return
+      done = true
+      events.close()
+      promise.then(resolve, reject)
+    }
+
+    events.addEventListener('progress', (e) => {
+      const progress: Progress = JSON.parse((e as MessageEvent<string>).data)
+      onProgress(progress)
+      if (progress.stage === 'done' || progress.stage === 'failed') {
+        finish(api.getDiscoveryJob(job.id))
+      }
+    })
+    // Events published before the stream was (re)opened are missed, such as the
+    // job finishing, so the job is checked whenever it opens.
+    events.addEventListener('open', async () => {
+      try {
+        const current = await api.getDiscoveryJob(job.id)
+        if (isFinished(current)) {
+          finish(Promise.resolve(current))
+        }
+      } catch (error) {
+        finish(Promise.reject(error))
+      }
+    })
+    events.addEventListener('error', () => {
+      // EventSource reconnects by itself unless the stream was refused.
+      if (events.readyState === EventSource.CLOSED) {
+        finish(pollDiscoveryJob(job))
+      }
+    })
+  })
+}
+
 export const api = {
   getCurrentUser: async (): Promise<User> => {
     const res = await fetchAPI(`${BASE_URL}/whoami`)
@@ -37,12 +97,25 @@ export const api = {
     return res.json()
   },
 
-  generateDiscoveryPlaylist: async (dryRun = false): Promise<Playlist> => {
-    const res = await fetchAPI(`${BASE_URL}/generate-discovery-playlist?dryrun=${dryRun}`)
-    const data = await res.json()
+  getDiscoveryJob: async (id: string): Promise<DiscoveryJob> => {
+    const res = await fetchAPI(`${BASE_URL}/discovery-jobs/${encodeURIComponent(id)}`)
+    return res.json()
+  },
+
+  /**
+   * Generates the playlist in a discovery job, which isn't bound by how long
+   * requests may take, and waits for it to finish. onProgress is called with
+   * every progress event of the job.
+   */
+  generateDiscoveryPlaylist: async (dryRun = false, onProgress: (progress: Progress) => void = () => {}): Promise<Playlist> => {
+    const res = await fetchAPI(`${BASE_URL}/discovery-jobs?dryrun=${dryRun}`, { method: 'POST' })
+    const job = await followDiscoveryJob(await res.json(), onProgress)
+    if (job.state === 'failed' || !job.playlist) {
+      throw new Error(`Generating discovery playlist failed: ${job.error ?? 'no playlist'}`)
+    }
     return {
-      ...data,
-      tracks: data.tracks.items?.map((item: any) => item.track) || data.tracks,
+      ...job.playlist,
+      tracks: job.playlist.tracks.items.map((item) => item.track),
     }
   },
 
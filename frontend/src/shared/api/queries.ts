@@ -1,5 +1,7 @@
+import { useState } from 'react'
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
 import { api } from './client'
+import type { Progress } from '@/shared/types/spotify'
 
 export const queryKeys = {
   user: ['user'] as const,
@@ -42,12 +44,20 @@ export function useIndexSummary(refetchInterval: number | false) {
   })
 }
 
+/**
+ * Generates a discovery playlist, progress is the latest progress of the
+ * generation while it's pending.
+ */
 export function useGenerateDiscoveryPlaylist() {
   const queryClient = useQueryClient()
-  return useMutation({
-    mutationFn: (dryRun: boolean = false) => api.generateDiscoveryPlaylist(dryRun),
+  const [progress, setProgress] = useState<Progress | null>(null)
+  const mutation = useMutation({
+    mutationFn: (dryRun: boolean = false) => api.generateDiscoveryPlaylist(dryRun, setProgress),
+    onMutate: () => setProgress(null),
+    onSettled: () => setProgress(null),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: queryKeys.indexSummary })
     },
   })
+  return { ...mutation, progress }
 }
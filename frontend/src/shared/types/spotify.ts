@@ -41,6 +41,22 @@ export interface Playlist extends SimplePlaylist {
   tracks: Track[]
 }
 
+// Progress of a long running operation, total is 0 when it isn't known.
+export interface Progress {
+  stage: string
+  detail?: string
+  current: number
+  total: number
+}
+
+export interface DiscoveryJob {
+  id: string
+  state: string
+  error?: string
+  // The playlist as returned by Spotify, with its tracks paged.
+  playlist?: Omit<Playlist, 'tracks'> & { tracks: { items: { track: Track }[] } }
+}
+
 export interface IndexSummary {
   playlist_count: number
   unique_track_count: number
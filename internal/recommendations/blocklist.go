@@ -0,0 +1,172 @@
+package recommendations
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/pkg/slogutil"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	BlockTrack  = "track"
+	BlockArtist = "artist"
+	BlockAlbum  = "album"
+)
+
+type BlocklistStore interface {
+	// Add adds the entry, replacing any existing entry with the same type and ID.
+	Add(ctx context.Context, userID string, entry BlocklistEntry) error
+	// Remove removes the entry and reports whether there was one.
+	Remove(ctx context.Context, userID, entryType, id string) (bool, error)
+	List(ctx context.Context, userID string) ([]BlocklistEntry, error)
+}
+
+// BlocklistEntry is a track, artist or album which is never recommended.
+// Tracks are identified by their track key, so other releases of a blocked track
+// are blocked as well. Artists and albums are identified by their Spotify IDs.
+type BlocklistEntry struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Track is the blocked track, it's stored so the entry can be rekeyed.
+	Track *spotify.SimpleTrack `json:"-"`
+}
+
+func (e BlocklistEntry) Validate() error {
+	fieldErrs := make(map[string]string)
+	switch e.Type {
+	case BlockTrack, BlockArtist, BlockAlbum:
+	default:
+		fieldErrs["type"] = fmt.Sprintf("must be one of %s, %s or %s", BlockTrack, BlockArtist, BlockAlbum)
+	}
+	if e.ID == "" {
+		fieldErrs["id"] = "must not be empty"
+	}
+	if len(fieldErrs) > 0 {
+		return ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+type ErrBlocklistEntryNotFound struct {
+	entryType, id string
+}
+
+func (err ErrBlocklistEntryNotFound) Error() string {
+	return fmt.Sprintf("%s %s is not blocklisted", err.entryType, err.id)
+}
+
+// blocklist matches tracks against a user's blocklist entries.
+type blocklist struct {
+	trackKeys map[string]bool
+	artistIDs map[spotify.ID]bool
+	albumIDs  map[spotify.ID]bool
+}
+
+func newBlocklist(entries []BlocklistEntry) blocklist {
+	b := blocklist{
+		trackKeys: make(map[string]bool),
+		artistIDs: make(map[spotify.ID]bool),
+		albumIDs:  make(map[spotify.ID]bool),
+	}
+	for _, e := range entries {
+		switch e.Type {
+		case BlockTrack:
+			b.trackKeys[e.ID] = true
+		case BlockArtist:
+			b.artistIDs[spotify.ID(e.ID)] = true
+		case BlockAlbum:
+			b.albumIDs[spotify.ID(e.ID)] = true
+		}
+	}
+	return b
+}
+
+func (b blocklist) blocks(track spotify.FullTrack, trackKey string) bool {
+	if b.trackKeys[trackKey] || b.albumIDs[track.Album.ID] {
+		return true
+	}
+	for _, a := range track.Artists {
+		if b.artistIDs[a.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *service) ListBlocklist(ctx context.Context) ([]BlocklistEntry, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting current user: %w", err)
+	}
+
+	entries, err := s.blocklist.List(ctx, usr.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing blocklist: %w", err)
+	}
+	return entries, nil
+}
+
+// AddToBlocklist blocklists the entry. Tracks are given by their Spotify IDs and
+// stored by their track keys. Names are looked up for tracks and albums, artists
+// keep the name they're given.
+func (s *service) AddToBlocklist(ctx context.Context, entry BlocklistEntry) (BlocklistEntry, error) {
+	if err := entry.Validate(); err != nil {
+		return BlocklistEntry{}, err
+	}
+
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return BlocklistEntry{}, fmt.Errorf("getting current user: %w", err)
+	}
+
+	switch entry.Type {
+	case BlockTrack:
+		track, err := s.spotify.GetTrack(ctx, entry.ID)
+		if err != nil {
+			return BlocklistEntry{}, fmt.Errorf("getting track %s: %w", entry.ID, err)
+		}
+		entry.ID = s.matcher.Key(track.SimpleTrack)
+		entry.Name = stringifyTrack(track.SimpleTrack)
+		entry.Track = &track.SimpleTrack
+	case BlockAlbum:
+		album, err := s.spotify.GetAlbum(ctx, entry.ID)
+		if err != nil {
+			return BlocklistEntry{}, fmt.Errorf("getting album %s: %w", entry.ID, err)
+		}
+		entry.Name = album.Name
+	}
+	entry.CreatedAt = time.Now()
+
+	if err := s.blocklist.Add(ctx, usr.ID, entry); err != nil {
+		return BlocklistEntry{}, fmt.Errorf("adding %s %s to blocklist: %w", entry.Type, entry.ID, err)
+	}
+	slog.InfoContext(slogutil.WithAttrs(ctx, slog.String("user", usr.ID)), "blocklisted", "type", entry.Type, "id", entry.ID, "name", entry.Name)
+	return entry, nil
+}
+
+// RemoveFromBlocklist removes the entry, tracks are given by their track keys as
+// listed by ListBlocklist.
+func (s *service) RemoveFromBlocklist(ctx context.Context, entryType, id string) error {
+	if err := (BlocklistEntry{Type: entryType, ID: id}).Validate(); err != nil {
+		return err
+	}
+
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("getting current user: %w", err)
+	}
+
+	removed, err := s.blocklist.Remove(ctx, usr.ID, entryType, id)
+	if err != nil {
+		return fmt.Errorf("removing %s %s from blocklist: %w", entryType, id, err)
+	}
+	if !removed {
+		return ErrBlocklistEntryNotFound{entryType: entryType, id: id}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package recommendations
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kristofferostlund/recommendli/pkg/slogutil"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	JobStateQueued          = "queued"
+	JobStateSyncingIndex    = StageSyncingIndex
+	JobStateScoring         = StageScoring
+	JobStateWritingPlaylist = StageWritingPlaylist
+	JobStateDone            = "done"
+	JobStateFailed          = "failed"
+)
+
+var jobStages = []string{JobStateSyncingIndex, JobStateScoring, JobStateWritingPlaylist}
+
+type DiscoveryJobStore interface {
+	Create(ctx context.Context, job DiscoveryJob) error
+	Update(ctx context.Context, job DiscoveryJob) error
+	Get(ctx context.Context, userID, jobID string) (DiscoveryJob, bool, error)
+	// Heartbeat records that the job is still running.
+	Heartbeat(ctx context.Context, userID, jobID string) error
+	// FailUnfinished marks the jobs which aren't done or failed as failed, if they
+	// were started by this instance or haven't had a heartbeat for staleAfter.
+	// Jobs run in the instance which started them, so this is used on startup to
+	// clear out the jobs interrupted by a restart, leaving other instances' be.
+	FailUnfinished(ctx context.Context, staleAfter time.Duration, reason string) (int, error)
+}
+
+const (
+	jobHeartbeatInterval = 30 * time.Second
+	// JobStaleAfter is how long a job goes without heartbeats before it's
+	// considered to have been interrupted.
+	JobStaleAfter = 5 * jobHeartbeatInterval
+)
+
+type DiscoveryJob struct {
+	ID        string                `json:"id"`
+	UserID    string                `json:"user_id"`
+	DryRun    bool                  `json:"dry_run"`
+	State     string                `json:"state"`
+	Progress  Progress              `json:"progress"`
+	Error     string                `json:"error,omitempty"`
+	Playlist  *spotify.FullPlaylist `json:"playlist,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+}
+
+func (j DiscoveryJob) Finished() bool {
+	return j.State == JobStateDone || j.State == JobStateFailed
+}
+
+type ErrJobNotFound struct {
+	jobID string
+}
+
+func (err ErrJobNotFound) Error() string {
+	return fmt.Sprintf("discovery job %s not found", err.jobID)
+}
+
+// StartDiscoveryJob queues a discovery playlist generation and runs it in the background.
+// The job outlives the request which started it and its state is persisted as it goes.
+func (s *service) StartDiscoveryJob(ctx context.Context, dryRun bool) (DiscoveryJob, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return DiscoveryJob{}, fmt.Errorf("getting current user: %w", err)
+	}
+
+	now := time.Now()
+	job := DiscoveryJob{
+		ID:        uuid.New().String(),
+		UserID:    usr.ID,
+		DryRun:    dryRun,
+		State:     JobStateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.discoveryJobs.Create(ctx, job); err != nil {
+		return DiscoveryJob{}, fmt.Errorf("creating discovery job: %w", err)
+	}
+
+	ctx = slogutil.WithAttrs(context.WithoutCancel(ctx), slog.String("job_id", job.ID), slog.String("called_by", "StartDiscoveryJob"))
+	ctx = WithEventKey(ctx, usr.ID, job.ID)
+	go s.runDiscoveryJob(ctx, job)
+
+	return job, nil
+}
+
+func (s *service) GetDiscoveryJob(ctx context.Context, jobID string) (DiscoveryJob, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return DiscoveryJob{}, fmt.Errorf("getting current user: %w", err)
+	}
+
+	job, exists, err := s.discoveryJobs.Get(ctx, usr.ID, jobID)
+	if err != nil {
+		return DiscoveryJob{}, fmt.Errorf("getting discovery job %s: %w", jobID, err)
+	}
+	if !exists {
+		return DiscoveryJob{}, ErrJobNotFound{jobID: jobID}
+	}
+	return job, nil
+}
+
+func (s *service) runDiscoveryJob(ctx context.Context, job DiscoveryJob) {
+	mux := &sync.Mutex{}
+	update := func(ctx context.Context, fn func(job *DiscoveryJob) bool) {
+		mux.Lock()
+		defer mux.Unlock()
+
+		if !fn(&job) {
+			return
+		}
+		job.UpdatedAt = time.Now()
+		if err := s.discoveryJobs.Update(ctx, job); err != nil {
+			slog.WarnContext(ctx, "ignoring failure to update discovery job", slogutil.Error(err))
+		}
+	}
+
+	ctx = WithProgress(ctx, func(ctx context.Context, p Progress) {
+		update(ctx, func(job *DiscoveryJob) bool {
+			// Only the top level stages are job states, the rest is
+			// available as events.
+			if !stringsContain(jobStages, p.Stage) {
+				return false
+			}
+			// Progress is reported concurrently when scoring,
+			// so it may arrive out of order.
+			if job.State == p.Stage && p.Current < job.Progress.Current {
+				return false
+			}
+			job.State = p.Stage
+			job.Progress = p
+			return true
+		})
+	})
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	defer stopHeartbeat()
+	go s.heartbeatDiscoveryJob(heartbeatCtx, job)
+
+	slog.InfoContext(ctx, "running discovery job")
+	playlist, _, err := s.generateDiscoveryPlaylist(ctx, job.DryRun)
+	if err != nil {
+		slog.ErrorContext(ctx, "discovery job failed", slogutil.Error(err))
+		update(ctx, func(job *DiscoveryJob) bool {
+			job.State = JobStateFailed
+			job.Error = err.Error()
+			return true
+		})
+		reportProgress(ctx, Progress{Stage: JobStateFailed, Detail: err.Error()})
+		return
+	}
+
+	slog.InfoContext(ctx, "discovery job done", "playlist", playlist.Name)
+	update(ctx, func(job *DiscoveryJob) bool {
+		job.State = JobStateDone
+		job.Playlist = &playlist
+		return true
+	})
+	reportProgress(ctx, Progress{Stage: JobStateDone, Detail: playlist.Name})
+}
+
+// heartbeatDiscoveryJob records that the job is running until ctx is cancelled,
+// as progress isn't reported often enough to tell whether it still is.
+func (s *service) heartbeatDiscoveryJob(ctx context.Context, job DiscoveryJob) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.discoveryJobs.Heartbeat(ctx, job.UserID, job.ID); err != nil {
+				slog.WarnContext(ctx, "ignoring failure to update discovery job heartbeat", slogutil.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package recommendations
+
+import (
+	"context"
+	"sync"
+)
+
+// Event is a Progress report published under the key of the request or job
+// which reported it.
+type Event struct {
+	Key string `json:"key"`
+	Progress
+}
+
+// EventBroker fans out events to everyone subscribed to the event's key. Keys
+// are per user, so users only get the events of their own requests and jobs.
+// Slow subscribers miss events rather than block the publisher.
+type EventBroker struct {
+	mux         *sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		mux:         &sync.RWMutex{},
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// brokerKey namespaces the key by the user publishing and subscribing to it.
+func brokerKey(userID, key string) string {
+	return userID + "/" + key
+}
+
+func (b *EventBroker) Subscribe(userID, key string) (<-chan Event, func()) {
+	key = brokerKey(userID, key)
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	c := make(chan Event, 64)
+	if _, exists := b.subscribers[key]; !exists {
+		b.subscribers[key] = make(map[chan Event]struct{})
+	}
+	b.subscribers[key][c] = struct{}{}
+
+	return c, func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+
+		delete(b.subscribers[key], c)
+		if len(b.subscribers[key]) == 0 {
+			delete(b.subscribers, key)
+		}
+	}
+}
+
+func (b *EventBroker) Publish(userID string, e Event) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	for c := range b.subscribers[brokerKey(userID, e.Key)] {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+type eventKeyCtxKey struct{}
+
+type eventKey struct {
+	userID string
+	key    string
+}
+
+// WithEventKey sets the user and key progress reported with ctx is published under.
+func WithEventKey(ctx context.Context, userID, key string) context.Context {
+	return context.WithValue(ctx, eventKeyCtxKey{}, eventKey{userID: userID, key: key})
+}
+
+func eventKeyFrom(ctx context.Context) (userID, key string) {
+	k, _ := ctx.Value(eventKeyCtxKey{}).(eventKey)
+	return k.userID, k.key
+}
@@ -2,14 +2,19 @@ package recommendations
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/kristofferostlund/recommendli/pkg/slogutil"
 	"github.com/kristofferostlund/recommendli/pkg/sortby"
 	"github.com/kristofferostlund/recommendli/pkg/srv"
@@ -18,6 +23,7 @@ import (
 
 const (
 	playlistIDKey = "playlistID"
+	jobIDKey      = "jobID"
 )
 
 func NewRouter(svcFactory *ServiceFactory, spotifyProviderFactory *SpotifyAdaptorFactory, auth *AuthAdaptor) *chi.Mux {
@@ -25,19 +31,37 @@ func NewRouter(svcFactory *ServiceFactory, spotifyProviderFactory *SpotifyAdapto
 		svcFactory:             svcFactory,
 		spotifyProviderFactory: spotifyProviderFactory,
 		auth:                   auth,
+		events:                 NewEventBroker(),
 	}
 	r := chi.NewRouter()
 
 	ar := r.With(auth.Middleware())
 	ar.Get("/v1/whoami", handler.withService(handler.whoami))
+	ar.Get("/v1/events", handler.withService(handler.streamEvents))
 	ar.Get("/v1/check-current-track-in-library", handler.withService(handler.checkCurrentTrackInLibrary))
 	ar.Get("/v1/generate-discovery-playlist", handler.withService(handler.generateDiscoveryPlaylist))
+	ar.Post("/v1/discovery-jobs", handler.withService(handler.startDiscoveryJob))
+	ar.Get("/v1/discovery-jobs/{jobID}", handler.withService(handler.getDiscoveryJob))
 	ar.Get("/v1/album-for-current-track", handler.withService(handler.getAlbumForCurrentTrack))
 	ar.Get("/v1/current-track", handler.withService(handler.getCurrentTrack))
 	ar.Get("/v1/playlists", handler.withService(handler.listPlaylists))
 	ar.Get("/v1/playlists/for", handler.withService(handler.getPlaylistMatchingPattern))
 	ar.Get("/v1/playlists/{playlistID}", handler.withService(handler.getPlaylist))
 	ar.Get("/v1/index/summary", handler.withService(handler.getIndexSummary))
+	ar.Get("/v1/index/stats", handler.withService(handler.getIndexStats))
+	ar.Get("/v1/index/tracks", handler.withService(handler.searchIndexTracks))
+	ar.Get("/v1/index/duplicates", handler.withService(handler.getIndexDuplicates))
+	ar.Get("/v1/index/history", handler.withService(handler.getIndexHistory))
+	ar.Get("/v1/preferences", handler.withService(handler.getPreferences))
+	ar.Put("/v1/preferences", handler.withService(handler.putPreferences))
+	ar.Patch("/v1/preferences", handler.withService(handler.patchPreferences))
+	ar.Post("/v1/preferences/preview", handler.withService(handler.previewPreferences))
+	ar.Get("/v1/schedule", handler.withService(handler.getSchedule))
+	ar.Put("/v1/schedule", handler.withService(handler.putSchedule))
+	ar.Delete("/v1/schedule", handler.withService(handler.deleteSchedule))
+	ar.Get("/v1/blocklist", handler.withService(handler.listBlocklist))
+	ar.Post("/v1/blocklist", handler.withService(handler.addToBlocklist))
+	ar.Delete("/v1/blocklist", handler.withService(handler.removeFromBlocklist))
 
 	return r
 }
@@ -46,19 +70,36 @@ type httpHandler struct {
 	svcFactory             *ServiceFactory
 	spotifyProviderFactory *SpotifyAdaptorFactory
 	auth                   *AuthAdaptor
+	events                 *EventBroker
 }
 
 type Service interface {
+	AddToBlocklist(ctx context.Context, entry BlocklistEntry) (BlocklistEntry, error)
 	CheckPlayingTrackInLibrary(ctx context.Context) (spotify.FullTrack, []spotify.SimplePlaylist, error)
 	CreateDiscoveryPlaylist(ctx context.Context) (spotify.FullPlaylist, error)
-	DryRunDiscoveryPlaylist(ctx context.Context) (spotify.FullPlaylist, error)
+	DeleteSchedule(ctx context.Context) error
+	DryRunDiscoveryPlaylist(ctx context.Context) (spotify.FullPlaylist, []ScoredTrack, error)
+	FindDuplicates(ctx context.Context, includeNear bool) ([]DuplicateTrack, error)
 	GetCurrentlyPlayingTrackAlbum(ctx context.Context) (spotify.FullAlbum, error)
 	GetCurrentTrack(ctx context.Context) (spotify.FullTrack, bool, error)
 	GetCurrentUser(ctx context.Context) (spotify.User, error)
 	GetCurrentUsersPlaylistMatchingPattern(ctx context.Context, pattern string) ([]spotify.FullPlaylist, error)
+	GetDiscoveryJob(ctx context.Context, jobID string) (DiscoveryJob, error)
+	GetIndexHistory(ctx context.Context, query HistoryQuery) ([]TrackEvent, error)
 	GetIndexSummary(ctx context.Context) (IndexSummary, error)
 	GetPlaylist(ctx context.Context, playlistID string) (spotify.FullPlaylist, error)
+	GetPreferences(ctx context.Context) (UserPreferences, error)
+	GetSchedule(ctx context.Context) (Schedule, bool, error)
+	GetTrackHistory(ctx context.Context, track spotify.FullTrack) ([]TrackEvent, error)
+	ListBlocklist(ctx context.Context) ([]BlocklistEntry, error)
 	ListPlaylistsForCurrentUser(ctx context.Context) ([]spotify.SimplePlaylist, error)
+	PreviewPreferences(ctx context.Context, prefs UserPreferences) (PreferencesPreview, error)
+	RemoveFromBlocklist(ctx context.Context, entryType, id string) error
+	SearchIndex(ctx context.Context, search TrackSearch) (TrackSearchResult, error)
+	SetPreferences(ctx context.Context, prefs UserPreferences) (UserPreferences, error)
+	SetSchedule(ctx context.Context, schedule Schedule) (Schedule, error)
+	TrackMatchKey(track spotify.SimpleTrack) string
+	StartDiscoveryJob(ctx context.Context, dryRun bool) (DiscoveryJob, error)
 }
 
 type spotifyClientHandlerFunc func(svc Service) http.HandlerFunc
@@ -69,12 +110,96 @@ func (h *httpHandler) withService(sHandler spotifyClientHandlerFunc) http.Handle
 		spotifyClient, err := h.auth.GetClient(r)
 		if err != nil && errors.Is(err, ErrNoAuthentication) {
 			srv.JSONError(w, fmt.Errorf("user not signed in: %w", err), srv.Status(http.StatusUnauthorized))
+			return
 		} else if err != nil {
 			slog.ErrorContext(ctx, "getting spotify client", slogutil.Error(err))
 			srv.InternalServerError(w, err)
 			return
 		}
-		sHandler(h.svcFactory.New(h.spotifyProviderFactory.New(spotifyClient)))(w, r)
+		userID, err := h.auth.UserID(r)
+		if err != nil {
+			srv.JSONError(w, fmt.Errorf("user not signed in: %w", err), srv.Status(http.StatusUnauthorized))
+			return
+		}
+		// Progress is published under the request ID, which clients can set
+		// themselves using the X-Request-Id header to follow it on /v1/events.
+		ctx = WithEventKey(ctx, userID, middleware.GetReqID(ctx))
+		ctx = WithProgress(ctx, func(ctx context.Context, p Progress) {
+			if userID, key := eventKeyFrom(ctx); key != "" {
+				h.events.Publish(userID, Event{Key: key, Progress: p})
+			}
+		})
+		sHandler(h.svcFactory.New(h.spotifyProviderFactory.New(spotifyClient)))(w, r.WithContext(ctx))
+	}
+}
+
+// streamEvents streams the progress events of a request or job as server-sent events.
+// Jobs are followed with the job query parameter and requests with the key query
+// parameter, set to the X-Request-Id of the request. Only the signed in user's
+// own jobs and requests can be followed.
+func (h *httpHandler) streamEvents(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, err := h.auth.UserID(r)
+		if err != nil {
+			srv.JSONError(w, fmt.Errorf("user not signed in: %w", err), srv.Status(http.StatusUnauthorized))
+			return
+		}
+		key, jobID := r.URL.Query().Get("key"), r.URL.Query().Get("job")
+		if (key == "") == (jobID == "") {
+			srv.JSONError(w, errors.New("either key or job must be provided"), srv.Status(400))
+			return
+		}
+		if jobID != "" {
+			if _, err := svc.GetDiscoveryJob(ctx, jobID); errors.As(err, &ErrJobNotFound{}) {
+				srv.JSONError(w, err, srv.Status(http.StatusNotFound))
+				return
+			} else if err != nil {
+				slog.ErrorContext(ctx, "getting discovery job", slogutil.Error(err))
+				srv.InternalServerError(w, err)
+				return
+			}
+			key = jobID
+		}
+		h.streamUserEvents(w, r, userID, key)
+	}
+}
+
+func (h *httpHandler) streamUserEvents(w http.ResponseWriter, r *http.Request, userID, key string) {
+	ctx := r.Context()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		srv.InternalServerError(w, errors.New("streaming not supported"))
+		return
+	}
+
+	events, unsubscribe := h.events.Subscribe(userID, key)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case e := <-events:
+			b, err := json.Marshal(e)
+			if err != nil {
+				slog.ErrorContext(ctx, "marshalling event", slogutil.Error(err))
+				return
+			}
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", b)
+		}
+		flusher.Flush()
 	}
 }
 
@@ -159,27 +284,85 @@ func (h *httpHandler) checkCurrentTrackInLibrary(svc Service) http.HandlerFunc {
 			srv.InternalServerError(w, err)
 			return
 		}
+		history, err := svc.GetTrackHistory(ctx, currentTrack)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting track history", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
 		srv.JSON(w, struct {
 			InLibrary bool                     `json:"in_library"`
 			Track     spotify.FullTrack        `json:"track"`
+			MatchKey  string                   `json:"match_key"`
 			Playlists []spotify.SimplePlaylist `json:"playlists"`
-		}{Track: currentTrack, Playlists: playlists, InLibrary: len(playlists) > 0})
+			Timeline  []timelineEntry          `json:"timeline"`
+		}{
+			Track:     currentTrack,
+			MatchKey:  svc.TrackMatchKey(currentTrack.SimpleTrack),
+			Playlists: playlists,
+			InLibrary: len(playlists) > 0,
+			Timeline:  toTimeline(history),
+		})
 	}
 }
 
+// timelineEntry is a TrackEvent with a human readable description, such as
+// "added to Metal 12 on 2024-03-02".
+type timelineEntry struct {
+	TrackEvent
+	Description string `json:"description"`
+}
+
+func toTimeline(events []TrackEvent) []timelineEntry {
+	timeline := make([]timelineEntry, 0, len(events))
+	for _, e := range events {
+		timeline = append(timeline, timelineEntry{TrackEvent: e, Description: e.Description()})
+	}
+	return timeline
+}
+
+// parsePaging parses the limit and offset query parameters, leaving them as zero
+// when they're not given.
+func parsePaging(query url.Values) (limit, offset int, err error) {
+	fieldErrs := make(map[string]string)
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			fieldErrs["limit"] = "must be an integer"
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil {
+			fieldErrs["offset"] = "must be an integer"
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return 0, 0, ValidationError{Fields: fieldErrs}
+	}
+	return limit, offset, nil
+}
+
 func (h *httpHandler) generateDiscoveryPlaylist(svc Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		var playlist spotify.FullPlaylist
-		var err error
 
 		dryRunStr := strings.ToLower(r.URL.Query().Get("dryrun"))
 		if dryRunStr == "true" {
-			playlist, err = svc.DryRunDiscoveryPlaylist(ctx)
-		} else {
-			playlist, err = svc.CreateDiscoveryPlaylist(ctx)
+			playlist, scores, err := svc.DryRunDiscoveryPlaylist(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "generating discovery playlist", slogutil.Error(err))
+				srv.InternalServerError(w, err)
+				return
+			}
+			// The scores are added next to the playlist fields so the response is
+			// still a valid playlist.
+			srv.JSON(w, struct {
+				spotify.FullPlaylist
+				Scores []ScoredTrack `json:"scores"`
+			}{playlist, scores})
+			return
 		}
 
+		playlist, err := svc.CreateDiscoveryPlaylist(ctx)
 		if err != nil {
 			slog.ErrorContext(ctx, "generating discovery playlist", slogutil.Error(err))
 			srv.InternalServerError(w, err)
@@ -189,6 +372,41 @@ func (h *httpHandler) generateDiscoveryPlaylist(svc Service) http.HandlerFunc {
 	}
 }
 
+func (h *httpHandler) startDiscoveryJob(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dryRun := strings.ToLower(r.URL.Query().Get("dryrun")) == "true"
+		job, err := svc.StartDiscoveryJob(ctx, dryRun)
+		if err != nil {
+			slog.ErrorContext(ctx, "starting discovery job", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, job, srv.Status(http.StatusAccepted))
+	}
+}
+
+func (h *httpHandler) getDiscoveryJob(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		jobID := chi.URLParam(r, jobIDKey)
+		if jobID == "" {
+			srv.JSONError(w, errors.New("missing job ID in path"), srv.Status(400))
+			return
+		}
+		job, err := svc.GetDiscoveryJob(ctx, jobID)
+		if err != nil && errors.As(err, &ErrJobNotFound{}) {
+			srv.JSONError(w, err, srv.Status(http.StatusNotFound))
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "getting discovery job", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, job)
+	}
+}
+
 func (h *httpHandler) getAlbumForCurrentTrack(svc Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -251,3 +469,388 @@ func (h *httpHandler) getIndexSummary(svc Service) http.HandlerFunc {
 		})
 	}
 }
+
+func (h *httpHandler) getIndexStats(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		summary, err := svc.GetIndexSummary(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting index stats", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, struct {
+			PlaylistCount     int                  `json:"playlist_count"`
+			UniqueTrackCount  int                  `json:"unique_track_count"`
+			TrackCount        int                  `json:"track_count"`
+			TopArtists        []ArtistTrackCount   `json:"top_artists"`
+			TracksPerPlaylist []PlaylistTrackCount `json:"tracks_per_playlist"`
+			ReleaseYears      []ReleaseYearCount   `json:"release_years"`
+			AlbumTypes        map[string]int       `json:"album_types"`
+		}{
+			PlaylistCount:     summary.PlaylistCount,
+			UniqueTrackCount:  summary.UniqueTrackCount,
+			TrackCount:        summary.TrackCount,
+			TopArtists:        summary.TopArtists,
+			TracksPerPlaylist: summary.TracksPerPlaylist,
+			ReleaseYears:      summary.ReleaseYears,
+			AlbumTypes:        summary.AlbumTypes,
+		})
+	}
+}
+
+func (h *httpHandler) searchIndexTracks(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		sortField, descending, err := ParseTrackSort(query.Get("sort"))
+		if err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+		limit, offset, err := parsePaging(query)
+		if err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+
+		result, err := svc.SearchIndex(ctx, TrackSearch{
+			Query:      query.Get("q"),
+			Artist:     query.Get("artist"),
+			Playlist:   query.Get("playlist"),
+			Sort:       sortField,
+			Descending: descending,
+			Limit:      limit,
+			Offset:     offset,
+		})
+		if err != nil && errors.As(err, &ValidationError{}) {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "searching track index", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, result)
+	}
+}
+
+func (h *httpHandler) getIndexDuplicates(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		includeNear := strings.ToLower(r.URL.Query().Get("near")) == "true"
+
+		duplicates, err := svc.FindDuplicates(ctx, includeNear)
+		if err != nil {
+			slog.ErrorContext(ctx, "finding duplicates", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, struct {
+			Count      int              `json:"count"`
+			Duplicates []DuplicateTrack `json:"duplicates"`
+		}{Count: len(duplicates), Duplicates: duplicates})
+	}
+}
+
+func (h *httpHandler) getIndexHistory(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		limit, offset, err := parsePaging(query)
+		if err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+
+		events, err := svc.GetIndexHistory(ctx, HistoryQuery{
+			Playlist: query.Get("playlist"),
+			Limit:    limit,
+			Offset:   offset,
+		})
+		if err != nil && errors.As(err, &ValidationError{}) {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "getting index history", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, struct {
+			Events []timelineEntry `json:"events"`
+		}{toTimeline(events)})
+	}
+}
+
+func (h *httpHandler) getPreferences(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		prefs, err := svc.GetPreferences(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting user preferences", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, prefs)
+	}
+}
+
+func (h *httpHandler) putPreferences(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body userPreferencesJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			srv.JSONError(w, fmt.Errorf("decoding request body: %w", err), srv.Status(400))
+			return
+		}
+		if err := body.missingFields(); err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+		prefs, err := body.applyTo(DefaultUserPreferences())
+		if err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+		h.setPreferences(svc, w, r, prefs)
+	}
+}
+
+func (h *httpHandler) patchPreferences(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var body userPreferencesJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			srv.JSONError(w, fmt.Errorf("decoding request body: %w", err), srv.Status(400))
+			return
+		}
+		current, err := svc.GetPreferences(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting user preferences", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		prefs, err := body.applyTo(current)
+		if err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+		h.setPreferences(svc, w, r, prefs)
+	}
+}
+
+func (h *httpHandler) setPreferences(svc Service, w http.ResponseWriter, r *http.Request, prefs UserPreferences) {
+	ctx := r.Context()
+	updated, err := svc.SetPreferences(ctx, prefs)
+	if err != nil {
+		slog.ErrorContext(ctx, "setting user preferences", slogutil.Error(err))
+		srv.InternalServerError(w, err)
+		return
+	}
+	srv.JSON(w, updated)
+}
+
+func (h *httpHandler) previewPreferences(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var body userPreferencesJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			srv.JSONError(w, fmt.Errorf("decoding request body: %w", err), srv.Status(400))
+			return
+		}
+		current, err := svc.GetPreferences(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting user preferences", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		prefs, err := body.preview().applyTo(current)
+		if err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+		preview, err := svc.PreviewPreferences(ctx, prefs)
+		if err != nil {
+			slog.ErrorContext(ctx, "previewing user preferences", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, struct {
+			Library           []spotify.SimplePlaylist `json:"library"`
+			Discovery         []spotify.SimplePlaylist `json:"discovery"`
+			Unclassified      []spotify.SimplePlaylist `json:"unclassified"`
+			IndexedTrackCount int                      `json:"indexed_track_count"`
+			LibraryTrackCount int                      `json:"library_track_count"`
+			TrackCountDelta   int                      `json:"track_count_delta"`
+		}{
+			Library:           preview.Library,
+			Discovery:         preview.Discovery,
+			Unclassified:      preview.Unclassified,
+			IndexedTrackCount: preview.IndexedTrackCount,
+			LibraryTrackCount: preview.LibraryTrackCount,
+			TrackCountDelta:   preview.TrackCountDelta(),
+		})
+	}
+}
+
+type scheduleJSON struct {
+	Enabled        bool       `json:"enabled"`
+	Weekday        string     `json:"weekday"`
+	Hour           int        `json:"hour"`
+	Minute         int        `json:"minute"`
+	Timezone       string     `json:"timezone"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastPlaylistID string     `json:"last_playlist_id,omitempty"`
+	FailedAttempts int        `json:"failed_attempts,omitempty"`
+}
+
+func toScheduleJSON(schedule Schedule) scheduleJSON {
+	return scheduleJSON{
+		Enabled:        schedule.Enabled,
+		Weekday:        strings.ToLower(schedule.Weekday.String()),
+		Hour:           schedule.Hour,
+		Minute:         schedule.Minute,
+		Timezone:       schedule.Timezone,
+		NextRunAt:      &schedule.NextRunAt,
+		LastRunAt:      schedule.LastRunAt,
+		LastError:      schedule.LastError,
+		LastPlaylistID: schedule.LastPlaylistID,
+		FailedAttempts: schedule.FailedAttempts,
+	}
+}
+
+func (h *httpHandler) getSchedule(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		schedule, exists, err := svc.GetSchedule(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting schedule", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		if !exists {
+			srv.JSONError(w, errors.New("no schedule set"), srv.Status(http.StatusNotFound))
+			return
+		}
+		srv.JSON(w, toScheduleJSON(schedule))
+	}
+}
+
+func (h *httpHandler) putSchedule(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		defaults := DefaultSchedule("")
+		body := toScheduleJSON(defaults)
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			srv.JSONError(w, fmt.Errorf("decoding request body: %w", err), srv.Status(400))
+			return
+		}
+		weekday, ok := ParseWeekday(body.Weekday)
+		if !ok {
+			srv.JSONError(w, ValidationError{Fields: map[string]string{"weekday": "must be a day of the week"}}, srv.Status(400))
+			return
+		}
+		schedule := Schedule{
+			Enabled:  body.Enabled,
+			Weekday:  weekday,
+			Hour:     body.Hour,
+			Minute:   body.Minute,
+			Timezone: body.Timezone,
+		}
+		if err := schedule.Validate(); err != nil {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		}
+
+		updated, err := svc.SetSchedule(ctx, schedule)
+		if err != nil && errors.Is(err, ErrNoToken) {
+			srv.JSONError(w, fmt.Errorf("sign in again to allow scheduling: %w", err), srv.Status(http.StatusUnauthorized))
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "setting schedule", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, toScheduleJSON(updated))
+	}
+}
+
+func (h *httpHandler) deleteSchedule(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := svc.DeleteSchedule(ctx); err != nil {
+			slog.ErrorContext(ctx, "deleting schedule", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *httpHandler) listBlocklist(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		entries, err := svc.ListBlocklist(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "listing blocklist", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, struct {
+			Entries []BlocklistEntry `json:"entries"`
+		}{entries})
+	}
+}
+
+// addToBlocklist blocklists a track, artist or album by its Spotify ID, e.g.
+// {"type": "track", "id": "4uLU6hMCjMI75M1A2tKUQC"}.
+func (h *httpHandler) addToBlocklist(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var body struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			srv.JSONError(w, fmt.Errorf("decoding request body: %w", err), srv.Status(400))
+			return
+		}
+
+		entry, err := svc.AddToBlocklist(ctx, BlocklistEntry{Type: body.Type, ID: body.ID, Name: body.Name})
+		if err != nil && errors.As(err, &ValidationError{}) {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "adding to blocklist", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		srv.JSON(w, entry, srv.Status(http.StatusCreated))
+	}
+}
+
+// removeFromBlocklist removes the entry given by the type and id query parameters.
+// Tracks are identified by the track key listed by GET /v1/blocklist.
+func (h *httpHandler) removeFromBlocklist(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+		err := svc.RemoveFromBlocklist(ctx, query.Get("type"), query.Get("id"))
+		if err != nil && errors.As(err, &ValidationError{}) {
+			srv.JSONError(w, err, srv.Status(400))
+			return
+		} else if err != nil && errors.As(err, &ErrBlocklistEntryNotFound{}) {
+			srv.JSONError(w, err, srv.Status(http.StatusNotFound))
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "removing from blocklist", slogutil.Error(err))
+			srv.InternalServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
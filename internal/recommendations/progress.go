@@ -0,0 +1,48 @@
+package recommendations
+
+import "context"
+
+const (
+	StageSyncingIndex           = "syncing_index"
+	StagePopulatingPlaylists    = "populating_playlists"
+	StageFetchingPlaylistTracks = "fetching_playlist_tracks"
+	StageScoring                = "scoring"
+	StageWritingPlaylist        = "writing_playlist"
+)
+
+// Progress describes how far along a long running operation is.
+// Total is 0 when the size of the stage isn't known.
+type Progress struct {
+	Stage   string `json:"stage"`
+	Detail  string `json:"detail,omitempty"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+}
+
+type ProgressFunc func(ctx context.Context, p Progress)
+
+type progressCtxKey struct{}
+
+// WithProgress returns a context which has fn called whenever progress is reported
+// by any of the service's long running operations. Any previously set ProgressFunc
+// is still called.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	prev := progressFuncFrom(ctx)
+	return context.WithValue(ctx, progressCtxKey{}, ProgressFunc(func(ctx context.Context, p Progress) {
+		if prev != nil {
+			prev(ctx, p)
+		}
+		fn(ctx, p)
+	}))
+}
+
+func progressFuncFrom(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressCtxKey{}).(ProgressFunc)
+	return fn
+}
+
+func reportProgress(ctx context.Context, p Progress) {
+	if fn := progressFuncFrom(ctx); fn != nil {
+		fn(ctx, p)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"log/slog"
 	"regexp"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/kristofferostlund/recommendli/pkg/singleflight"
@@ -33,12 +32,26 @@ type UserPreferenceProvider interface {
 	GetPreferences(ctx context.Context, userID string) (UserPreferences, error)
 }
 
+type UserPreferenceStore interface {
+	UserPreferenceProvider
+	SetPreferences(ctx context.Context, userID string, prefs UserPreferences) error
+}
+
 type UserPreferences struct {
-	LibraryPattern                   *regexp.Regexp
-	DiscoveryPlaylistNames           []string
-	WeightedWords                    map[string]int
+	LibraryPattern         *regexp.Regexp
+	DiscoveryPlaylistNames []string
+	WeightedWords          map[string]int
+	// MinimumAlbumSize, MinimumPopularity and MaximumTrackDuration are the
+	// thresholds of the filters, each of them disabled when zero.
 	MinimumAlbumSize                 int
+	MinimumPopularity                int
+	MaximumTrackDuration             time.Duration
 	RecommendationPlaylistNamePrefix string
+	// RecommendationCooldown is how long a recommended track is kept from being
+	// recommended again, it's set in whole weeks and disabled when zero.
+	RecommendationCooldown time.Duration
+	// Scorers is keyed by scorer name, scorers missing from it are disabled.
+	Scorers map[string]ScorerPreference
 }
 
 func (u UserPreferences) IsDiscoveryPlaylistName(name string) bool {
@@ -49,22 +62,42 @@ func (u UserPreferences) IsLibraryPlaylistName(name string) bool {
 	return !u.IsDiscoveryPlaylistName(name) && u.LibraryPattern.MatchString(name)
 }
 
+func (u UserPreferences) RecommendationCooldownWeeks() int {
+	return int(u.RecommendationCooldown / week)
+}
+
 func (u UserPreferences) RecommendationPlaylistName(kind string, now time.Time) string {
 	return fmt.Sprintf("%s %s %s", u.RecommendationPlaylistNamePrefix, kind, now.Format("2006-01-02"))
 }
 
 type ServiceFactory struct {
 	store           KeyValueStore
-	userPreferences UserPreferenceProvider
+	userPreferences UserPreferenceStore
 	trackIndex      TrackIndex
+	matcher         *TrackMatcher
+	ranker          *Ranker
+	discoveryJobs   DiscoveryJobStore
+	schedules       ScheduleStore
+	tokens          TokenStore
+	blocklist       BlocklistStore
+	recommended     RecommendationStore
 	sfSyncIndex     singleflight.DoFunc[[]spotify.SimplePlaylist]
 }
 
-func NewServiceFactory(store KeyValueStore, userPreferences UserPreferenceProvider, trackIndex TrackIndex, sfLocker singleflight.Locker) *ServiceFactory {
+// NewServiceFactory returns a ServiceFactory. The matcher must be the one the trackIndex
+// keys its tracks by.
+func NewServiceFactory(store KeyValueStore, userPreferences UserPreferenceStore, trackIndex TrackIndex, matcher *TrackMatcher, discoveryJobs DiscoveryJobStore, schedules ScheduleStore, tokens TokenStore, blocklist BlocklistStore, recommended RecommendationStore, sfLocker singleflight.Locker) *ServiceFactory {
 	return &ServiceFactory{
 		store:           store,
 		userPreferences: userPreferences,
 		trackIndex:      trackIndex,
+		matcher:         matcher,
+		discoveryJobs:   discoveryJobs,
+		schedules:       schedules,
+		tokens:          tokens,
+		blocklist:       blocklist,
+		recommended:     recommended,
+		ranker:          NewDefaultRanker(),
 		sfSyncIndex:     singleflight.Prepare[[]spotify.SimplePlaylist](sfLocker, 500*time.Millisecond),
 	}
 }
@@ -75,36 +108,35 @@ func (f *ServiceFactory) New(spotifyProvider SpotifyProvider) *service {
 		userPreferences: f.userPreferences,
 		spotify:         spotifyProvider,
 		trackIndex:      f.trackIndex,
+		matcher:         f.matcher,
+		ranker:          f.ranker,
+		discoveryJobs:   f.discoveryJobs,
+		schedules:       f.schedules,
+		tokens:          f.tokens,
+		blocklist:       f.blocklist,
+		recommended:     f.recommended,
 		sfSyncIndex:     f.sfSyncIndex,
 	}
 }
 
 type service struct {
 	store           KeyValueStore
-	userPreferences UserPreferenceProvider
+	userPreferences UserPreferenceStore
 	spotify         SpotifyProvider
 	trackIndex      TrackIndex
+	matcher         *TrackMatcher
+	ranker          *Ranker
+	discoveryJobs   DiscoveryJobStore
+	schedules       ScheduleStore
+	tokens          TokenStore
+	blocklist       BlocklistStore
+	recommended     RecommendationStore
 	sfSyncIndex     singleflight.DoFunc[[]spotify.SimplePlaylist]
 }
 
-type score struct {
-	track          spotify.FullTrack
-	album          spotify.FullAlbum
-	artistRelevace int
-}
-
-func (s score) keep(prefs UserPreferences) bool {
-	return len(s.album.Tracks.Tracks) >= prefs.MinimumAlbumSize
-}
-
-func (s score) calculate(prefs UserPreferences) int {
-	value := 0
-	for word, penalty := range prefs.WeightedWords {
-		if strings.Contains(strings.ToLower(s.track.Name), strings.ToLower(word)) {
-			value += penalty
-		}
-	}
-	return value + s.artistRelevace + s.album.ReleaseDateTime().Year() - 2000 + s.album.Tracks.Total
+// TrackMatchKey returns the key the track is matched against the library by.
+func (s *service) TrackMatchKey(track spotify.SimpleTrack) string {
+	return s.matcher.Key(track)
 }
 
 func (s *service) ListPlaylistsForCurrentUser(ctx context.Context) ([]spotify.SimplePlaylist, error) {
@@ -190,7 +222,7 @@ func (s *service) CheckPlayingTrackInLibrary(ctx context.Context) (spotify.FullT
 		return spotify.FullTrack{}, nil, fmt.Errorf("getting track index: %w", err)
 	}
 
-	pls, err := s.trackIndex.Lookup(ctx, usr.ID, currentTrack.SimpleTrack)
+	pls, err := s.trackIndex.Lookup(ctx, usr.ID, currentTrack)
 	if err != nil {
 		return spotify.FullTrack{}, nil, fmt.Errorf("looking up track in library: %w", err)
 	}
@@ -209,14 +241,100 @@ func (s *service) CheckPlayingTrackInLibrary(ctx context.Context) (spotify.FullT
 
 func (s *service) CreateDiscoveryPlaylist(ctx context.Context) (spotify.FullPlaylist, error) {
 	ctx = slogutil.WithAttrs(ctx, slog.String("called_by", "CreateDiscoveryPlaylist"))
-	return s.generateDiscoveryPlaylist(ctx, false)
+	playlist, _, err := s.generateDiscoveryPlaylist(ctx, false)
+	return playlist, err
 }
 
-func (s *service) DryRunDiscoveryPlaylist(ctx context.Context) (spotify.FullPlaylist, error) {
+// DryRunDiscoveryPlaylist returns the discovery playlist which would be created
+// along with the score breakdown of every candidate track, including the ones
+// which were dropped.
+func (s *service) DryRunDiscoveryPlaylist(ctx context.Context) (spotify.FullPlaylist, []ScoredTrack, error) {
 	ctx = slogutil.WithAttrs(ctx, slog.String("called_by", "DryRunDiscoveryPlaylist"))
 	return s.generateDiscoveryPlaylist(ctx, true)
 }
 
+func (s *service) GetPreferences(ctx context.Context) (UserPreferences, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return UserPreferences{}, fmt.Errorf("getting user: %w", err)
+	}
+
+	prefs, err := s.userPreferences.GetPreferences(ctx, usr.ID)
+	if err != nil {
+		return UserPreferences{}, fmt.Errorf("getting user preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func (s *service) SetPreferences(ctx context.Context, prefs UserPreferences) (UserPreferences, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return UserPreferences{}, fmt.Errorf("getting user: %w", err)
+	}
+
+	if err := s.userPreferences.SetPreferences(ctx, usr.ID, prefs); err != nil {
+		return UserPreferences{}, fmt.Errorf("setting user preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+type PreferencesPreview struct {
+	Library      []spotify.SimplePlaylist
+	Discovery    []spotify.SimplePlaylist
+	Unclassified []spotify.SimplePlaylist
+	// IndexedTrackCount and LibraryTrackCount are the summed track totals of the
+	// playlists currently in the track index and the playlists that would be in it
+	// with the previewed preferences. Tracks on several playlists are counted once
+	// per playlist, so the delta is an estimate of how much a re-sync would change.
+	IndexedTrackCount int
+	LibraryTrackCount int
+}
+
+func (p PreferencesPreview) TrackCountDelta() int {
+	return p.LibraryTrackCount - p.IndexedTrackCount
+}
+
+// PreviewPreferences classifies the current user's playlists according to prefs
+// without storing them or syncing the track index.
+func (s *service) PreviewPreferences(ctx context.Context, prefs UserPreferences) (PreferencesPreview, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return PreferencesPreview{}, fmt.Errorf("getting user: %w", err)
+	}
+
+	playlists, err := s.spotify.ListPlaylists(ctx, usr.ID)
+	if err != nil {
+		return PreferencesPreview{}, fmt.Errorf("listing playlists: %w", err)
+	}
+
+	summary, err := s.trackIndex.Summarize(ctx, usr.ID)
+	if err != nil {
+		return PreferencesPreview{}, fmt.Errorf("getting index summary: %w", err)
+	}
+
+	preview := PreferencesPreview{
+		Library:      make([]spotify.SimplePlaylist, 0),
+		Discovery:    make([]spotify.SimplePlaylist, 0),
+		Unclassified: make([]spotify.SimplePlaylist, 0),
+	}
+	for _, p := range playlists {
+		switch {
+		case prefs.IsDiscoveryPlaylistName(p.Name):
+			preview.Discovery = append(preview.Discovery, p)
+		case prefs.IsLibraryPlaylistName(p.Name):
+			preview.Library = append(preview.Library, p)
+			preview.LibraryTrackCount += int(p.Tracks.Total)
+		default:
+			preview.Unclassified = append(preview.Unclassified, p)
+		}
+	}
+	for _, p := range summary.Playlists {
+		preview.IndexedTrackCount += int(p.Tracks.Total)
+	}
+
+	return preview, nil
+}
+
 func (s *service) GetIndexSummary(ctx context.Context) (IndexSummary, error) {
 	usr, err := s.GetCurrentUser(ctx)
 	if err != nil {
@@ -236,20 +354,115 @@ func (s *service) GetIndexSummary(ctx context.Context) (IndexSummary, error) {
 	return summary, nil
 }
 
-func (s *service) generateDiscoveryPlaylist(ctx context.Context, dryRun bool) (spotify.FullPlaylist, error) {
+func (s *service) SearchIndex(ctx context.Context, search TrackSearch) (TrackSearchResult, error) {
+	if search.Limit == 0 {
+		search.Limit = defaultTrackSearchLimit
+	}
+	if search.Sort == "" {
+		search.Sort = TrackSortName
+	}
+	if err := search.Validate(); err != nil {
+		return TrackSearchResult{}, err
+	}
+
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return TrackSearchResult{}, fmt.Errorf("getting user: %w", err)
+	}
+
+	// Searches are served from the index as of its last sync, syncing it on every
+	// keystroke would mean listing all playlists from Spotify each time.
+	result, err := s.trackIndex.Search(ctx, usr.ID, search)
+	if err != nil {
+		return TrackSearchResult{}, fmt.Errorf("searching track index: %w", err)
+	}
+
+	return result, nil
+}
+
+func (s *service) GetIndexHistory(ctx context.Context, query HistoryQuery) ([]TrackEvent, error) {
+	if query.Limit == 0 {
+		query.Limit = defaultHistoryLimit
+	}
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+
+	ctx = slogutil.WithAttrs(ctx, slog.String("called_by", "GetIndexHistory"))
+	if _, err := s.getPlaylistsAndSyncIndex(ctx, usr.ID); err != nil {
+		return nil, fmt.Errorf("getting track index for user: %w", err)
+	}
+
+	events, err := s.trackIndex.History(ctx, usr.ID, query)
+	if err != nil {
+		return nil, fmt.Errorf("getting track index history: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetTrackHistory returns when the track was added to and removed from library
+// playlists, oldest first. It doesn't sync the index, which is expected to have
+// been done by the caller.
+func (s *service) GetTrackHistory(ctx context.Context, track spotify.FullTrack) ([]TrackEvent, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+
+	events, err := s.trackIndex.TrackHistory(ctx, usr.ID, track)
+	if err != nil {
+		return nil, fmt.Errorf("getting history of track %s (%s): %w", track.Name, track.ID, err)
+	}
+
+	return events, nil
+}
+
+// FindDuplicates lists the tracks on several library playlists. Near duplicates
+// are found by matching tracks loosely, regardless of the configured strictness.
+func (s *service) FindDuplicates(ctx context.Context, includeNear bool) ([]DuplicateTrack, error) {
 	usr, err := s.GetCurrentUser(ctx)
 	if err != nil {
-		return spotify.FullPlaylist{}, fmt.Errorf("getting current user: %w", err)
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+
+	ctx = slogutil.WithAttrs(ctx, slog.String("called_by", "FindDuplicates"))
+	if _, err := s.getPlaylistsAndSyncIndex(ctx, usr.ID); err != nil {
+		return nil, fmt.Errorf("getting track index for user: %w", err)
+	}
+
+	var nearKey func(spotify.SimpleTrack) string
+	if includeNear {
+		nearKey = NewTrackMatcher(MatchLoose).Key
+	}
+	duplicates, err := s.trackIndex.Duplicates(ctx, usr.ID, nearKey)
+	if err != nil {
+		return nil, fmt.Errorf("finding duplicates in track index: %w", err)
 	}
 
+	return duplicates, nil
+}
+
+func (s *service) generateDiscoveryPlaylist(ctx context.Context, dryRun bool) (spotify.FullPlaylist, []ScoredTrack, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("getting current user: %w", err)
+	}
+
+	reportProgress(ctx, Progress{Stage: StageSyncingIndex})
 	playlists, err := s.getPlaylistsAndSyncIndex(ctx, usr.ID)
 	if err != nil {
-		return spotify.FullPlaylist{}, fmt.Errorf("syncing index: %w", err)
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("syncing index: %w", err)
 	}
 
 	prefs, err := s.userPreferences.GetPreferences(ctx, usr.ID)
 	if err != nil {
-		return spotify.FullPlaylist{}, fmt.Errorf("getting user prefences: %w", err)
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("getting user prefences: %w", err)
 	}
 	discoveryPlaylists := filterSimplePlaylist(playlists, func(p spotify.SimplePlaylist) bool {
 		return prefs.IsDiscoveryPlaylistName(p.Name)
@@ -257,15 +470,26 @@ func (s *service) generateDiscoveryPlaylist(ctx context.Context, dryRun bool) (s
 
 	populatedDiscovery, err := s.spotify.PopulatePlaylists(ctx, discoveryPlaylists)
 	if err != nil {
-		return spotify.FullPlaylist{}, fmt.Errorf("populating discovery playlists when generating discovery playlist: %w", err)
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("populating discovery playlists when generating discovery playlist: %w", err)
+	}
+
+	blocklistEntries, err := s.blocklist.List(ctx, usr.ID)
+	if err != nil {
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("listing blocklist when generating discovery playlist: %w", err)
 	}
+	blocked := newBlocklist(blocklistEntries)
 
-	slog.DebugContext(ctx, "discovery playlists fully listed", "unique song count", len(uniqueTracks(tracksFor(populatedDiscovery))), "playlist count", len(populatedDiscovery))
+	slog.DebugContext(ctx, "discovery playlists fully listed", "unique song count", len(uniqueTracks(tracksFor(populatedDiscovery), s.matcher.Key)), "playlist count", len(populatedDiscovery))
 	candidates := make([]spotify.FullTrack, 0)
-	for _, t := range uniqueTracks(tracksFor(populatedDiscovery)) {
-		has, err := s.trackIndex.Has(ctx, usr.ID, t.SimpleTrack)
+	for _, t := range uniqueTracks(tracksFor(populatedDiscovery), s.matcher.Key) {
+		if blocked.blocks(t, s.matcher.Key(t.SimpleTrack)) {
+			slog.DebugContext(ctx, "candidate track", "track", stringifyTrack(t.SimpleTrack), "blocklisted", true)
+			continue
+		}
+
+		has, err := s.trackIndex.Has(ctx, usr.ID, t)
 		if err != nil {
-			return spotify.FullPlaylist{}, fmt.Errorf("checking if track is in library when generating discovery playlist: %w", err)
+			return spotify.FullPlaylist{}, nil, fmt.Errorf("checking if track is in library when generating discovery playlist: %w", err)
 		}
 
 		slog.DebugContext(ctx, "candidate track", "track", stringifyTrack(t.SimpleTrack), "in_library", has)
@@ -274,33 +498,40 @@ func (s *service) generateDiscoveryPlaylist(ctx context.Context, dryRun bool) (s
 		}
 	}
 
-	scores, err := s.scoreTracks(ctx, usr.ID, candidates)
+	playlistName := prefs.RecommendationPlaylistName("discovery", time.Now())
+
+	reportProgress(ctx, Progress{Stage: StageScoring, Total: len(candidates)})
+	scored, err := s.scoreTracks(ctx, usr.ID, prefs, playlistName, candidates)
 	if err != nil {
-		return spotify.FullPlaylist{}, fmt.Errorf("getting most relevant versions of tracks when generating discovery playlist: %w", err)
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("getting most relevant versions of tracks when generating discovery playlist: %w", err)
 	}
 
-	sort.SliceStable(scores, func(i, j int) bool {
-		return scores[i].calculate(prefs) > scores[j].calculate(prefs)
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score.Total > scored[j].Score.Total
 	})
 	tracks := make([]spotify.FullTrack, 0)
-	for _, s := range scores {
-		keep := s.keep(prefs)
-		slog.DebugContext(ctx, "track score", "track", stringifyTrack(s.track.SimpleTrack), "score", s.calculate(prefs), "keep", keep)
-		if keep {
-			tracks = append(tracks, s.track)
+	recommended := make([]RecommendedTrack, 0)
+	for _, s := range scored {
+		slog.DebugContext(ctx, "track score", "track", stringifyTrack(s.Track.SimpleTrack), "score", s.Score.Total, "keep", s.Score.Kept)
+		if s.Score.Kept {
+			tracks = append(tracks, s.Track)
+			recommended = append(recommended, RecommendedTrack{Key: s.MatchKey, TrackID: s.Track.ID.String(), Name: stringifyTrack(s.Track.SimpleTrack), Track: s.Track.SimpleTrack})
 		}
 	}
 
-	playlistName := prefs.RecommendationPlaylistName("discovery", time.Now())
 	if dryRun {
 		dummy := dummyPlaylistFor(playlistName, tracks)
 		slog.InfoContext(ctx, "recommendation complete, not creating playlist", "dryrun", dryRun, "playlist", dummy.Name, "tracks", printableTracks(tracksOf(dummy)), "track count", dummy.Tracks.Total)
-		return dummy, nil
+		return dummy, scored, nil
 	}
+	reportProgress(ctx, Progress{Stage: StageWritingPlaylist, Total: len(tracks)})
 	playlist, err := s.upsertPlaylistByName(ctx, playlists, usr.ID, playlistName, trackIDsOf(tracks))
 	if err != nil {
-		return spotify.FullPlaylist{}, fmt.Errorf("setting discovery playlist %s for user %s: %w", playlistName, usr.ID, err)
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("setting discovery playlist %s for user %s: %w", playlistName, usr.ID, err)
+	}
+	if err := s.recommended.Record(ctx, usr.ID, playlistName, time.Now(), recommended); err != nil {
+		return spotify.FullPlaylist{}, nil, fmt.Errorf("recording recommended tracks of %s for user %s: %w", playlistName, usr.ID, err)
 	}
 	slog.InfoContext(ctx, "recommendation complete", "playlist", playlist.Name, "tracks", printableTracks(tracksOf(playlist)), "track count", playlist.Tracks.Total)
-	return playlist, nil
+	return playlist, scored, nil
 }
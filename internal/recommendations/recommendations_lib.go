@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/kristofferostlund/recommendli/pkg/paginator"
 	"github.com/kristofferostlund/recommendli/pkg/slogutil"
@@ -46,6 +47,7 @@ func (s *service) getPlaylistsAndSyncIndex(ctx context.Context, userID string) (
 		}
 
 		slog.DebugContext(ctx, "populating tracks for track index", "added", len(added), "changed", len(changed), "removed", len(removed))
+		reportProgress(ctx, Progress{Stage: StageSyncingIndex, Detail: "populating playlists", Total: len(added) + len(changed)})
 
 		addedPlaylists, err := s.spotify.PopulatePlaylists(ctx, added)
 		if err != nil {
@@ -55,18 +57,23 @@ func (s *service) getPlaylistsAndSyncIndex(ctx context.Context, userID string) (
 		if err != nil {
 			return nil, fmt.Errorf("populating changed playlists: %w", err)
 		}
-		removedPlaylists, err := s.spotify.PopulatePlaylists(ctx, removed)
-		if err != nil {
-			return nil, fmt.Errorf("populating removed playlists: %w", err)
-		}
 
 		slog.DebugContext(ctx, "syncing track index")
+		reportProgress(ctx, Progress{Stage: StageSyncingIndex, Detail: "writing track index", Current: len(added) + len(changed), Total: len(added) + len(changed)})
 
-		if err := s.trackIndex.Sync(ctx, userID, addedPlaylists, changedPlaylists, removedPlaylists); err != nil {
+		stats, err := s.trackIndex.Sync(ctx, userID, addedPlaylists, changedPlaylists, removed)
+		if err != nil {
 			return nil, fmt.Errorf("syncing track index: %w", err)
 		}
 
-		slog.DebugContext(ctx, "track index successfully synced")
+		slog.InfoContext(ctx, "track index successfully synced",
+			"playlists_added", stats.PlaylistsAdded,
+			"playlists_changed", stats.PlaylistsChanged,
+			"playlists_removed", stats.PlaylistsRemoved,
+			"playlist_tracks_added", stats.PlaylistTracksAdded,
+			"playlist_tracks_removed", stats.PlaylistTracksRemoved,
+			"tracks_orphaned", stats.TracksOrphaned,
+		)
 
 		return playlists, nil
 	})
@@ -165,10 +172,13 @@ func (s *service) trackAndAlbum(ctx context.Context, track spotify.FullTrack) (s
 	return track, album, nil
 }
 
-func (s *service) scoreTracks(ctx context.Context, userID string, tracks []spotify.FullTrack) ([]score, error) {
+// scoreTracks scores the tracks to be put on the playlist, the recommendations
+// previously made on the same playlist are ignored so regenerating it doesn't
+// drop its own tracks.
+func (s *service) scoreTracks(ctx context.Context, userID string, prefs UserPreferences, playlistName string, tracks []spotify.FullTrack) ([]ScoredTrack, error) {
 	type indexAndTrack struct {
 		index  int
-		scores []score
+		scores []ScoredTrack
 	}
 	pgtr := paginator.New(
 		paginator.Parallelism(10),
@@ -183,10 +193,31 @@ func (s *service) scoreTracks(ctx context.Context, userID string, tracks []spoti
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	artistIDs := make([]spotify.ID, 0)
+	for _, t := range tracks {
+		for _, a := range t.Artists {
+			artistIDs = append(artistIDs, a.ID)
+		}
+	}
+	artistTrackCounts, err := s.trackIndex.CountTracksByArtists(ctx, userID, artistIDs)
+	if err != nil {
+		return nil, fmt.Errorf("counting tracks by artists: %w", err)
+	}
+
+	trackKeys := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		trackKeys = append(trackKeys, s.matcher.Key(t.SimpleTrack))
+	}
+	previousRecommendations, err := s.recommended.Previous(ctx, userID, trackKeys, playlistName)
+	if err != nil {
+		return nil, fmt.Errorf("getting previous recommendations: %w", err)
+	}
+
+	var scoredCount atomic.Int64
 	go func() {
 		defer close(trackChan)
 		if err := pgtr.Run(ctx, func(i int, opts paginator.PageOpts, next paginator.NextFunc) (result *paginator.NextResult, err error) {
-			scores := make([]score, 0)
+			scores := make([]ScoredTrack, 0)
 			from, to := opts.Offset, opts.Offset+opts.Limit
 			for _, t := range tracks[from:to] {
 				if t.ID.String() == "" {
@@ -197,17 +228,20 @@ func (s *service) scoreTracks(ctx context.Context, userID string, tracks []spoti
 				if err != nil {
 					return nil, err
 				}
-				artistRelevace := 0
-				for _, a := range track.Artists {
-					ar, err := s.trackIndex.CountTracksByArtist(ctx, userID, a.Name)
-					if err != nil {
-						return nil, fmt.Errorf("counting tracks by artist %s: %w", a.Name, err)
-					}
-					artistRelevace += ar
+				breakdown, err := s.ranker.Rank(ctx, Candidate{
+					UserID:                  userID,
+					Track:                   track,
+					Album:                   album,
+					ArtistTrackCounts:       artistTrackCounts,
+					PreviouslyRecommendedAt: previousRecommendations[s.matcher.Key(t.SimpleTrack)],
+				}, prefs)
+				if err != nil {
+					return nil, fmt.Errorf("ranking track %s: %w", stringifyTrack(track.SimpleTrack), err)
 				}
-				scores = append(scores, score{track: track, album: album, artistRelevace: artistRelevace})
+				scores = append(scores, ScoredTrack{Track: track, Album: album.SimpleAlbum, MatchKey: s.matcher.Key(track.SimpleTrack), Score: breakdown})
 			}
 			slog.DebugContext(ctx, "getting most relevant tracks", "total count", len(tracks), "batch size", to-from, "from", from, "to", to)
+			reportProgress(ctx, Progress{Stage: StageScoring, Current: int(scoredCount.Add(int64(to - from))), Total: len(tracks)})
 			trackChan <- indexAndTrack{i, scores}
 			return next(len(tracks)), nil
 		}); err != nil {
@@ -233,7 +267,7 @@ loop:
 		}
 	}
 
-	mostRelevant := make([]score, 0)
+	mostRelevant := make([]ScoredTrack, 0)
 	for _, indexed := range indexedScores {
 		mostRelevant = append(mostRelevant, indexed.scores...)
 	}
@@ -244,13 +278,26 @@ loop:
 func (s *service) upsertPlaylistByName(ctx context.Context, existingPlaylists []spotify.SimplePlaylist, userID, playlistName string, trackIDs []string) (spotify.FullPlaylist, error) {
 	for _, p := range existingPlaylists {
 		if p.Name == playlistName {
+			reportProgress(ctx, Progress{Stage: StageWritingPlaylist, Detail: fmt.Sprintf("truncating %s", playlistName), Total: len(trackIDs)})
 			if err := s.spotify.TruncatePlaylist(ctx, p.ID.String(), p.SnapshotID); err != nil {
 				return spotify.FullPlaylist{}, fmt.Errorf("truncating playlist %s: %w", p.ID, err)
 			}
-			return s.spotify.SetPlaylistTracks(ctx, p.ID.String(), trackIDs)
+			reportProgress(ctx, Progress{Stage: StageWritingPlaylist, Detail: fmt.Sprintf("adding tracks to %s", playlistName), Total: len(trackIDs)})
+			playlist, err := s.spotify.SetPlaylistTracks(ctx, p.ID.String(), trackIDs)
+			if err != nil {
+				return spotify.FullPlaylist{}, err
+			}
+			reportProgress(ctx, Progress{Stage: StageWritingPlaylist, Detail: playlistName, Current: len(trackIDs), Total: len(trackIDs)})
+			return playlist, nil
 		}
 	}
-	return s.spotify.CreatePlaylist(ctx, userID, playlistName, trackIDs)
+	reportProgress(ctx, Progress{Stage: StageWritingPlaylist, Detail: fmt.Sprintf("creating %s", playlistName), Total: len(trackIDs)})
+	playlist, err := s.spotify.CreatePlaylist(ctx, userID, playlistName, trackIDs)
+	if err != nil {
+		return spotify.FullPlaylist{}, err
+	}
+	reportProgress(ctx, Progress{Stage: StageWritingPlaylist, Detail: playlistName, Current: len(trackIDs), Total: len(trackIDs)})
+	return playlist, nil
 }
 
 func dummyPlaylistFor(name string, tracks []spotify.FullTrack) spotify.FullPlaylist {
@@ -342,12 +389,13 @@ func printableTracks(tracks []spotify.FullTrack) []string {
 	return printable
 }
 
-func uniqueTracks(tracks []spotify.FullTrack) []spotify.FullTrack {
+func uniqueTracks(tracks []spotify.FullTrack, keyFunc func(spotify.SimpleTrack) string) []spotify.FullTrack {
 	seen := make(map[string]struct{})
 	unique := make([]spotify.FullTrack, 0)
 	for _, t := range tracks {
-		if _, isSeen := seen[stringifyTrack(t.SimpleTrack)]; !isSeen {
-			seen[stringifyTrack(t.SimpleTrack)] = struct{}{}
+		key := keyFunc(t.SimpleTrack)
+		if _, isSeen := seen[key]; !isSeen {
+			seen[key] = struct{}{}
 			unique = append(unique, t)
 		}
 	}
@@ -0,0 +1,25 @@
+package recommendations
+
+import (
+	"context"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// RecommendationStore keeps track of which tracks have been recommended, and when.
+type RecommendationStore interface {
+	// Record stores the tracks put on the playlist, replacing the tracks previously
+	// recorded for it as playlists are regenerated when run more than once a day.
+	Record(ctx context.Context, userID, playlistName string, recommendedAt time.Time, tracks []RecommendedTrack) error
+	// Previous returns when each of the tracks was recommended, newest first, leaving
+	// out the recommendations made on the excluded playlist.
+	Previous(ctx context.Context, userID string, trackKeys []string, excludePlaylistName string) (map[string][]time.Time, error)
+}
+
+type RecommendedTrack struct {
+	Key     string
+	TrackID string
+	Name    string
+	Track   spotify.SimpleTrack
+}
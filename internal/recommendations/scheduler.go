@@ -0,0 +1,286 @@
+package recommendations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/pkg/singleflight"
+	"github.com/kristofferostlund/recommendli/pkg/slogutil"
+)
+
+type ScheduleStore interface {
+	GetSchedule(ctx context.Context, userID string) (Schedule, bool, error)
+	PutSchedule(ctx context.Context, schedule Schedule) error
+	DeleteSchedule(ctx context.Context, userID string) error
+	// ListDueSchedules lists the enabled schedules whose next run is at or before now.
+	ListDueSchedules(ctx context.Context, now time.Time) ([]Schedule, error)
+}
+
+// Schedule is a weekly discovery playlist generation for a user.
+type Schedule struct {
+	UserID         string
+	Enabled        bool
+	Weekday        time.Weekday
+	Hour           int
+	Minute         int
+	Timezone       string
+	NextRunAt      time.Time
+	LastRunAt      *time.Time
+	LastError      string
+	LastPlaylistID string
+	// FailedAttempts is the number of runs in a row which have failed.
+	FailedAttempts int
+}
+
+const (
+	// maxScheduleAttempts is how many times a run is tried before giving up until
+	// the next week.
+	maxScheduleAttempts     = 6
+	scheduleRetryBackoff    = 5 * time.Minute
+	scheduleMaxRetryBackoff = 2 * time.Hour
+)
+
+// DefaultSchedule runs Monday mornings, after Discover Weekly has been refreshed.
+func DefaultSchedule(userID string) Schedule {
+	return Schedule{
+		UserID:   userID,
+		Enabled:  true,
+		Weekday:  time.Monday,
+		Hour:     9,
+		Minute:   0,
+		Timezone: "UTC",
+	}
+}
+
+func (s Schedule) Validate() error {
+	fieldErrs := make(map[string]string)
+	if s.Weekday < time.Sunday || s.Weekday > time.Saturday {
+		fieldErrs["weekday"] = "must be a day of the week"
+	}
+	if s.Hour < 0 || s.Hour > 23 {
+		fieldErrs["hour"] = "must be between 0 and 23"
+	}
+	if s.Minute < 0 || s.Minute > 59 {
+		fieldErrs["minute"] = "must be between 0 and 59"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		fieldErrs["timezone"] = fmt.Sprintf("must be a valid IANA time zone: %s", err)
+	}
+	if len(fieldErrs) > 0 {
+		return ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// NextRun returns the first time the schedule should run strictly after after.
+func (s Schedule) NextRun(after time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("loading time zone %s: %w", s.Timezone, err)
+	}
+	local := after.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), s.Hour, s.Minute, 0, 0, loc)
+	next = next.AddDate(0, 0, (int(s.Weekday)-int(next.Weekday())+7)%7)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next.UTC(), nil
+}
+
+// RetryAt returns when to retry a run which has failed FailedAttempts times in a
+// row, backing off exponentially. It's never later than the next regular run.
+func (s Schedule) RetryAt(now time.Time) (time.Time, error) {
+	next, err := s.NextRun(now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	backoff := scheduleRetryBackoff << max(s.FailedAttempts-1, 0)
+	if backoff > scheduleMaxRetryBackoff || backoff <= 0 {
+		backoff = scheduleMaxRetryBackoff
+	}
+	if retryAt := now.Add(backoff); retryAt.Before(next) {
+		return retryAt, nil
+	}
+	return next, nil
+}
+
+func ParseWeekday(v string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), v) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+var ErrNoToken = errors.New("no stored token")
+
+func (s *service) GetSchedule(ctx context.Context) (Schedule, bool, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return Schedule{}, false, fmt.Errorf("getting current user: %w", err)
+	}
+	return s.schedules.GetSchedule(ctx, usr.ID)
+}
+
+// SetSchedule stores the schedule for the current user. The user's stored token
+// is needed to create playlists on the user's behalf.
+func (s *service) SetSchedule(ctx context.Context, schedule Schedule) (Schedule, error) {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("getting current user: %w", err)
+	}
+	token, exists, err := s.tokens.GetToken(ctx, usr.ID)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("getting token: %w", err)
+	}
+	if !exists || token.RefreshToken == "" {
+		return Schedule{}, ErrNoToken
+	}
+
+	schedule.UserID = usr.ID
+	if schedule.NextRunAt, err = schedule.NextRun(time.Now()); err != nil {
+		return Schedule{}, err
+	}
+
+	if err := s.schedules.PutSchedule(ctx, schedule); err != nil {
+		return Schedule{}, fmt.Errorf("storing schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+func (s *service) DeleteSchedule(ctx context.Context) error {
+	usr, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("getting current user: %w", err)
+	}
+	return s.schedules.DeleteSchedule(ctx, usr.ID)
+}
+
+// Scheduler polls for due schedules and creates discovery playlists for them.
+// Runs are guarded by the singleflight locker, so several instances can run
+// a scheduler without creating the same playlist twice.
+type Scheduler struct {
+	svcFactory             *ServiceFactory
+	spotifyProviderFactory *SpotifyAdaptorFactory
+	auth                   *AuthAdaptor
+	schedules              ScheduleStore
+	tokens                 TokenStore
+	sfRun                  singleflight.DoFunc[struct{}]
+	interval               time.Duration
+}
+
+func NewScheduler(svcFactory *ServiceFactory, spotifyProviderFactory *SpotifyAdaptorFactory, auth *AuthAdaptor, schedules ScheduleStore, tokens TokenStore, sfLocker singleflight.Locker, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		svcFactory:             svcFactory,
+		spotifyProviderFactory: spotifyProviderFactory,
+		auth:                   auth,
+		schedules:              schedules,
+		tokens:                 tokens,
+		sfRun:                  singleflight.Prepare[struct{}](sfLocker, 30*time.Second),
+		interval:               interval,
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.runDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context) {
+	due, err := s.schedules.ListDueSchedules(ctx, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "listing due schedules", slogutil.Error(err))
+		return
+	}
+
+	for _, schedule := range due {
+		ctx := slogutil.WithAttrs(ctx, slog.String("user", schedule.UserID), slog.String("called_by", "Scheduler"))
+		key := fmt.Sprintf("Scheduler:%s", schedule.UserID)
+		if _, err := s.sfRun(ctx, key, func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, s.runSchedule(ctx, schedule.UserID)
+		}); err != nil {
+			slog.ErrorContext(ctx, "running schedule", slogutil.Error(err))
+		}
+	}
+}
+
+func (s *Scheduler) runSchedule(ctx context.Context, userID string) error {
+	// Another instance may have run the schedule while we were waiting for the lock.
+	schedule, exists, err := s.schedules.GetSchedule(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("getting schedule: %w", err)
+	}
+	now := time.Now()
+	if !exists || !schedule.Enabled || schedule.NextRunAt.After(now) {
+		slog.DebugContext(ctx, "schedule no longer due")
+		return nil
+	}
+
+	slog.InfoContext(ctx, "running scheduled discovery playlist generation")
+	playlistID, runErr := s.createDiscoveryPlaylist(ctx, userID)
+	if runErr != nil {
+		slog.ErrorContext(ctx, "scheduled discovery playlist generation failed", slogutil.Error(runErr))
+	}
+
+	schedule.LastRunAt = &now
+	schedule.LastError = ""
+	if playlistID != "" {
+		schedule.LastPlaylistID = playlistID
+	}
+	// Failed runs are retried soon, as most failures are transient, and only
+	// skipped until the next week once they've failed too many times in a row.
+	if runErr != nil {
+		schedule.LastError = runErr.Error()
+		schedule.FailedAttempts++
+	} else {
+		schedule.FailedAttempts = 0
+	}
+	if runErr != nil && schedule.FailedAttempts < maxScheduleAttempts {
+		schedule.NextRunAt, err = schedule.RetryAt(now)
+	} else {
+		schedule.FailedAttempts = 0
+		schedule.NextRunAt, err = schedule.NextRun(now)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.schedules.PutSchedule(ctx, schedule); err != nil {
+		return fmt.Errorf("updating schedule: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) createDiscoveryPlaylist(ctx context.Context, userID string) (string, error) {
+	token, exists, err := s.tokens.GetToken(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("getting token: %w", err)
+	}
+	if !exists {
+		return "", ErrNoToken
+	}
+
+	client := s.auth.NewClient(ctx, userID, token)
+	svc := s.svcFactory.New(s.spotifyProviderFactory.New(client))
+	playlist, err := svc.CreateDiscoveryPlaylist(ctx)
+	if err != nil {
+		return "", err
+	}
+	return playlist.ID.String(), nil
+}
@@ -0,0 +1,281 @@
+package recommendations
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+const (
+	ScorerWeightedWords          = "weighted_words"
+	ScorerArtistFamiliarity      = "artist_familiarity"
+	ScorerRecency                = "recency"
+	ScorerAlbumSize              = "album_size"
+	ScorerPopularity             = "popularity"
+	ScorerDuration               = "duration"
+	ScorerIgnoredRecommendations = "ignored_recommendations"
+
+	FilterMinimumAlbumSize       = "minimum_album_size"
+	FilterMinimumPopularity      = "minimum_popularity"
+	FilterMaximumTrackDuration   = "maximum_track_duration"
+	FilterRecommendationCooldown = "recommendation_cooldown"
+
+	// ignoredRecommendationPenalty is the score a track loses for every time it has
+	// been recommended before without being added to the library.
+	ignoredRecommendationPenalty = -10
+
+	// maxScorerWeight bounds the weights, in either direction, so that weighted
+	// scores stay far from overflowing when they're rounded to ints.
+	maxScorerWeight = 1000
+)
+
+// Candidate is a track which might end up on a discovery playlist.
+// ArtistTrackCounts holds the number of library tracks by each of the track's
+// artists and PreviouslyRecommendedAt when the track has been recommended before,
+// newest first. Both are looked up for all candidates at once.
+type Candidate struct {
+	UserID                  string
+	Track                   spotify.FullTrack
+	Album                   spotify.FullAlbum
+	ArtistTrackCounts       map[spotify.ID]int
+	PreviouslyRecommendedAt []time.Time
+}
+
+// Scorer returns the raw, unweighted, score of a candidate along with the input
+// the score is based on. The weight is applied by the caller according to the
+// user's ScorerPreference.
+type Scorer interface {
+	Score(ctx context.Context, c Candidate, prefs UserPreferences) (value int, input any, err error)
+}
+
+type ScorerFunc func(ctx context.Context, c Candidate, prefs UserPreferences) (value int, input any, err error)
+
+func (f ScorerFunc) Score(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	return f(ctx, c, prefs)
+}
+
+// Filter decides whether a candidate is kept at all, regardless of its score.
+// Filters are configured by a threshold in the preferences rather than being
+// enabled like scorers, and a zero threshold disables the filter: it must keep
+// everything.
+type Filter interface {
+	Keep(ctx context.Context, c Candidate, prefs UserPreferences) (keep bool, reason string, err error)
+}
+
+type FilterFunc func(ctx context.Context, c Candidate, prefs UserPreferences) (keep bool, reason string, err error)
+
+func (f FilterFunc) Keep(ctx context.Context, c Candidate, prefs UserPreferences) (bool, string, error) {
+	return f(ctx, c, prefs)
+}
+
+type ScorerPreference struct {
+	Enabled bool    `json:"enabled"`
+	Weight  float64 `json:"weight"`
+}
+
+// ScoredTrack is a discovery candidate along with how it was scored.
+type ScoredTrack struct {
+	Track    spotify.FullTrack   `json:"track"`
+	Album    spotify.SimpleAlbum `json:"album"`
+	MatchKey string              `json:"match_key"`
+	Score    ScoreBreakdown      `json:"score"`
+}
+
+// ScoreBreakdown explains how a track's score was calculated. Total is the sum
+// of all component contributions and Kept is false if the track was dropped,
+// in which case DropReason says why.
+type ScoreBreakdown struct {
+	Components []ScoreComponent `json:"components"`
+	Total      int              `json:"total"`
+	Kept       bool             `json:"kept"`
+	DropReason string           `json:"drop_reason,omitempty"`
+}
+
+type ScoreComponent struct {
+	Name         string  `json:"name"`
+	Input        any     `json:"input"`
+	Value        int     `json:"value"`
+	Weight       float64 `json:"weight"`
+	Contribution int     `json:"contribution"`
+}
+
+// Ranker scores and filters candidates using the scorers enabled in the user's
+// preferences. Every filter is run, but the ones whose threshold is zero keep
+// every candidate.
+type Ranker struct {
+	scorers map[string]Scorer
+	filters map[string]Filter
+}
+
+func NewRanker(scorers map[string]Scorer, filters map[string]Filter) *Ranker {
+	return &Ranker{scorers: scorers, filters: filters}
+}
+
+// NewDefaultRanker returns a Ranker with all built in scorers and filters.
+func NewDefaultRanker() *Ranker {
+	return NewRanker(
+		map[string]Scorer{
+			ScorerWeightedWords:          ScorerFunc(scoreWeightedWords),
+			ScorerArtistFamiliarity:      ScorerFunc(scoreArtistFamiliarity),
+			ScorerRecency:                ScorerFunc(scoreRecency),
+			ScorerAlbumSize:              ScorerFunc(scoreAlbumSize),
+			ScorerPopularity:             ScorerFunc(scorePopularity),
+			ScorerDuration:               ScorerFunc(scoreDuration),
+			ScorerIgnoredRecommendations: ScorerFunc(scoreIgnoredRecommendations),
+		},
+		map[string]Filter{
+			FilterMinimumAlbumSize:       FilterFunc(filterMinimumAlbumSize),
+			FilterMinimumPopularity:      FilterFunc(filterMinimumPopularity),
+			FilterMaximumTrackDuration:   FilterFunc(filterMaximumTrackDuration),
+			FilterRecommendationCooldown: FilterFunc(filterRecommendationCooldown),
+		},
+	)
+}
+
+func (r *Ranker) Rank(ctx context.Context, c Candidate, prefs UserPreferences) (ScoreBreakdown, error) {
+	breakdown := ScoreBreakdown{Components: make([]ScoreComponent, 0), Kept: true}
+
+	for _, name := range sortedKeys(r.scorers) {
+		pref, ok := prefs.Scorers[name]
+		if !ok || !pref.Enabled {
+			continue
+		}
+		value, input, err := r.scorers[name].Score(ctx, c, prefs)
+		if err != nil {
+			return ScoreBreakdown{}, fmt.Errorf("scoring %s: %w", name, err)
+		}
+		contribution := int(math.Round(float64(value) * pref.Weight))
+		breakdown.Components = append(breakdown.Components, ScoreComponent{
+			Name:         name,
+			Input:        input,
+			Value:        value,
+			Weight:       pref.Weight,
+			Contribution: contribution,
+		})
+		breakdown.Total += contribution
+	}
+
+	for _, name := range sortedKeys(r.filters) {
+		keep, reason, err := r.filters[name].Keep(ctx, c, prefs)
+		if err != nil {
+			return ScoreBreakdown{}, fmt.Errorf("filtering %s: %w", name, err)
+		}
+		if !keep {
+			breakdown.Kept = false
+			breakdown.DropReason = fmt.Sprintf("%s: %s", name, reason)
+			break
+		}
+	}
+
+	return breakdown, nil
+}
+
+func scoreWeightedWords(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	value := 0
+	matched := make([]string, 0)
+	for word, penalty := range prefs.WeightedWords {
+		if strings.Contains(strings.ToLower(c.Track.Name), strings.ToLower(word)) {
+			value += penalty
+			matched = append(matched, word)
+		}
+	}
+	sort.Strings(matched)
+	return value, matched, nil
+}
+
+func scoreArtistFamiliarity(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	value := 0
+	for _, a := range c.Track.Artists {
+		value += c.ArtistTrackCounts[a.ID]
+	}
+	return value, value, nil
+}
+
+func scoreRecency(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	year := c.Album.ReleaseDateTime().Year()
+	return year - 2000, year, nil
+}
+
+func scoreAlbumSize(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	return c.Album.Tracks.Total, c.Album.Tracks.Total, nil
+}
+
+func scorePopularity(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	return c.Track.Popularity, c.Track.Popularity, nil
+}
+
+func scoreDuration(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	duration := c.Track.TimeDuration()
+	return int(duration.Minutes()), duration.String(), nil
+}
+
+// scoreIgnoredRecommendations demotes tracks which have been recommended before.
+// Candidates are never in the library, so every previous recommendation was ignored.
+func scoreIgnoredRecommendations(ctx context.Context, c Candidate, prefs UserPreferences) (int, any, error) {
+	count := len(c.PreviouslyRecommendedAt)
+	return count * ignoredRecommendationPenalty, count, nil
+}
+
+func filterMinimumAlbumSize(ctx context.Context, c Candidate, prefs UserPreferences) (bool, string, error) {
+	// Only the first page of the album's tracks is fetched, so they're counted by the total.
+	if c.Album.Tracks.Total < prefs.MinimumAlbumSize {
+		return false, fmt.Sprintf("album has %d tracks, minimum album size is %d", c.Album.Tracks.Total, prefs.MinimumAlbumSize), nil
+	}
+	return true, "", nil
+}
+
+func filterMinimumPopularity(ctx context.Context, c Candidate, prefs UserPreferences) (bool, string, error) {
+	if c.Track.Popularity < prefs.MinimumPopularity {
+		return false, fmt.Sprintf("track popularity is %d, minimum popularity is %d", c.Track.Popularity, prefs.MinimumPopularity), nil
+	}
+	return true, "", nil
+}
+
+func filterMaximumTrackDuration(ctx context.Context, c Candidate, prefs UserPreferences) (bool, string, error) {
+	if prefs.MaximumTrackDuration > 0 && c.Track.TimeDuration() > prefs.MaximumTrackDuration {
+		return false, fmt.Sprintf("track is %s long, maximum duration is %s", c.Track.TimeDuration(), prefs.MaximumTrackDuration), nil
+	}
+	return true, "", nil
+}
+
+func filterRecommendationCooldown(ctx context.Context, c Candidate, prefs UserPreferences) (bool, string, error) {
+	if prefs.RecommendationCooldown <= 0 || len(c.PreviouslyRecommendedAt) == 0 {
+		return true, "", nil
+	}
+	if last := c.PreviouslyRecommendedAt[0]; time.Since(last) < prefs.RecommendationCooldown {
+		return false, fmt.Sprintf("track was recommended on %s, cooldown is %d weeks", last.Format(time.DateOnly), prefs.RecommendationCooldownWeeks()), nil
+	}
+	return true, "", nil
+}
+
+// defaultScorerPreferences are the preferences of every scorer. Stored and updated
+// preferences are merged on top of them, so scorers added later start out with
+// these rather than being disabled.
+func defaultScorerPreferences() map[string]ScorerPreference {
+	return map[string]ScorerPreference{
+		ScorerWeightedWords:          {Enabled: true, Weight: 1},
+		ScorerArtistFamiliarity:      {Enabled: true, Weight: 1},
+		ScorerRecency:                {Enabled: true, Weight: 1},
+		ScorerAlbumSize:              {Enabled: true, Weight: 1},
+		ScorerPopularity:             {Enabled: false, Weight: 1},
+		ScorerDuration:               {Enabled: false, Weight: 1},
+		ScorerIgnoredRecommendations: {Enabled: true, Weight: 1},
+	}
+}
+
+// scorerNames are the scorers which preferences can be set for.
+var scorerNames = sortedKeys(defaultScorerPreferences())
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,70 @@
+package recommendations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+func TestDefaultRankerScorersHaveDefaultPreferences(t *testing.T) {
+	defaults := defaultScorerPreferences()
+	for name := range NewDefaultRanker().scorers {
+		if _, ok := defaults[name]; !ok {
+			t.Errorf("scorer %s has no default preference, so its preference can't be set", name)
+		}
+	}
+	for name := range defaults {
+		if _, ok := NewDefaultRanker().scorers[name]; !ok {
+			t.Errorf("default preference for unknown scorer %s", name)
+		}
+	}
+}
+
+func TestRankWithZeroFilterThresholds(t *testing.T) {
+	c := Candidate{
+		Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{Name: "Long Track", Duration: int((time.Hour).Milliseconds())},
+			Popularity:  0,
+		},
+		PreviouslyRecommendedAt: []time.Time{time.Now()},
+	}
+	c.Album.Tracks.Total = 1
+	prefs := UserPreferences{Scorers: map[string]ScorerPreference{}}
+
+	breakdown, err := NewDefaultRanker().Rank(context.Background(), c, prefs)
+	if err != nil {
+		t.Fatalf("Rank: %v", err)
+	}
+	if !breakdown.Kept {
+		t.Errorf("candidate was dropped with every filter disabled: %s", breakdown.DropReason)
+	}
+}
+
+func TestFilterMinimumAlbumSizeCountsAllPages(t *testing.T) {
+	prefs := UserPreferences{MinimumAlbumSize: 4}
+	tests := []struct {
+		name     string
+		fetched  int
+		total    int
+		wantKeep bool
+	}{
+		{name: "album larger than the fetched page", fetched: 2, total: 60, wantKeep: true},
+		{name: "small album", fetched: 2, total: 2, wantKeep: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Candidate{}
+			c.Album.Tracks.Tracks = make([]spotify.SimpleTrack, tt.fetched)
+			c.Album.Tracks.Total = tt.total
+			keep, reason, err := filterMinimumAlbumSize(context.Background(), c, prefs)
+			if err != nil {
+				t.Fatalf("filterMinimumAlbumSize: %v", err)
+			}
+			if keep != tt.wantKeep {
+				t.Errorf("filterMinimumAlbumSize() = %t (%s), want %t", keep, reason, tt.wantKeep)
+			}
+		})
+	}
+}
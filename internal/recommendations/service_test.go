@@ -0,0 +1,189 @@
+package recommendations_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+	"github.com/kristofferostlund/recommendli/internal/recommendations/spotifytest"
+	"github.com/kristofferostlund/recommendli/internal/sqlite"
+	"github.com/kristofferostlund/recommendli/pkg/migrations"
+	"github.com/kristofferostlund/recommendli/pkg/secretbox"
+	"github.com/zmb3/spotify"
+)
+
+// newTestDB returns a migrated in-memory database which lives until the test ends.
+func newTestDB(t *testing.T) *sqlite.DB {
+	t.Helper()
+
+	raw, err := sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", filepath.Base(t.Name())))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	dir, err := filepath.Abs("../../migrations")
+	if err != nil {
+		t.Fatalf("resolving migrations: %v", err)
+	}
+	if err := migrations.UpSQLite("file://"+dir, raw.DB); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return sqlite.Wrap(raw)
+}
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, secretbox.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return key
+}
+
+// newTestService returns a service backed by an in-memory database, acting as
+// the provider's current user.
+func newTestService(t *testing.T, provider *spotifytest.Provider) recommendations.Service {
+	t.Helper()
+
+	db := newTestDB(t)
+	box, err := secretbox.New(newTestKey(t))
+	if err != nil {
+		t.Fatalf("creating secretbox: %v", err)
+	}
+
+	matcher := recommendations.NewTrackMatcher(recommendations.MatchExact)
+	factory := recommendations.NewServiceFactory(
+		sqlite.NewKeyValueStore(db, "cache"),
+		sqlite.NewUserPreferenceStore(db, recommendations.DefaultUserPreferences()),
+		sqlite.NewTrackIndex(db, matcher.Key),
+		matcher,
+		sqlite.NewDiscoveryJobStore(db, "test"),
+		sqlite.NewScheduleStore(db),
+		sqlite.NewTokenStore(db, box),
+		sqlite.NewBlocklistStore(db),
+		sqlite.NewRecommendationStore(db),
+		sqlite.NewLocker(db),
+	)
+	return factory.New(provider)
+}
+
+func trackIDs(tracks []spotify.FullTrack) []string {
+	ids := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		ids = append(ids, t.ID.String())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestServiceGeneratesDiscoveryPlaylist(t *testing.T) {
+	ctx := context.Background()
+
+	p := spotifytest.New()
+	p.AddUser(spotifytest.User("kristoffer"))
+	slayer := spotifytest.Artist("artist-1", "Slayer")
+	spotifytest.NewAlbum("album-1", "Reign in Blood").By(slayer).Released("1986-10-07").
+		Track("track-1", "Angel of Death").
+		Track("track-2", "Piece by Piece").
+		Track("track-3", "Necrophobic").
+		Track("track-4", "Altar of Sacrifice").
+		AddTo(p)
+	spotifytest.NewAlbum("album-2", "Repentless").By(slayer).Released("2015-09-11").
+		Track("track-5", "Delusions of Saviour").
+		Track("track-6", "Repentless").
+		Track("track-7", "Take Control").
+		Track("track-8", "Vices").
+		Track("track-9", "Cast the First Stone").
+		AddTo(p)
+	spotifytest.NewAlbum("album-3", "Single").By(spotifytest.Artist("artist-2", "Unknown")).Released("2024-01-01").
+		Track("track-10", "Single Track").
+		AddTo(p)
+	library := p.AddPlaylist("kristoffer", "Metal 1", "track-1", "track-2")
+	p.AddPlaylist("kristoffer", "Discover Weekly", "track-1", "track-5", "track-6", "track-7", "track-10")
+
+	svc := newTestService(t, p)
+
+	if _, err := svc.AddToBlocklist(ctx, recommendations.BlocklistEntry{Type: recommendations.BlockTrack, ID: "track-7"}); err != nil {
+		t.Fatalf("AddToBlocklist: %v", err)
+	}
+
+	_, scores, err := svc.DryRunDiscoveryPlaylist(ctx)
+	if err != nil {
+		t.Fatalf("DryRunDiscoveryPlaylist: %v", err)
+	}
+	// track-1 is in the library and track-7 is blocklisted, so neither is scored.
+	scored := make(map[string]recommendations.ScoredTrack)
+	for _, s := range scores {
+		scored[s.Track.ID.String()] = s
+	}
+	for _, id := range []string{"track-1", "track-7"} {
+		if _, ok := scored[id]; ok {
+			t.Errorf("%s was scored", id)
+		}
+	}
+	for _, id := range []string{"track-5", "track-6"} {
+		if s, ok := scored[id]; !ok || !s.Score.Kept {
+			t.Errorf("%s wasn't kept: %+v", id, s)
+		}
+	}
+	if s, ok := scored["track-10"]; !ok || s.Score.Kept {
+		t.Errorf("track-10 of a single wasn't dropped: %+v", s)
+	}
+
+	// The library was indexed while generating the playlist.
+	result, err := svc.SearchIndex(ctx, recommendations.TrackSearch{Query: "slayer"})
+	if err != nil {
+		t.Fatalf("SearchIndex: %v", err)
+	}
+	indexed := make([]string, 0, len(result.Tracks))
+	for _, track := range result.Tracks {
+		indexed = append(indexed, track.Track.ID.String())
+	}
+	sort.Strings(indexed)
+	if fmt.Sprint(indexed) != fmt.Sprint([]string{"track-1", "track-2"}) {
+		t.Errorf("indexed tracks = %v, want track-1 and track-2", indexed)
+	}
+
+	playlist, err := svc.CreateDiscoveryPlaylist(ctx)
+	if err != nil {
+		t.Fatalf("CreateDiscoveryPlaylist: %v", err)
+	}
+	created, err := p.GetPlaylist(ctx, playlist.ID.String())
+	if err != nil {
+		t.Fatalf("getting created playlist: %v", err)
+	}
+	got := make([]spotify.FullTrack, 0)
+	for _, track := range created.Tracks.Tracks {
+		got = append(got, track.Track)
+	}
+	if fmt.Sprint(trackIDs(got)) != fmt.Sprint([]string{"track-5", "track-6"}) {
+		t.Errorf("created playlist has tracks %v, want track-5 and track-6", trackIDs(got))
+	}
+
+	// The index is only synced again once a library playlist changes.
+	populateCalls := p.Calls("PopulatePlaylists")
+	if _, err := svc.GetIndexSummary(ctx); err != nil {
+		t.Fatalf("GetIndexSummary: %v", err)
+	}
+	if calls := p.Calls("PopulatePlaylists") - populateCalls; calls != 0 {
+		t.Errorf("syncing an unchanged index populated playlists %d times", calls)
+	}
+
+	if _, err := p.SetPlaylistTracks(ctx, library.ID.String(), []string{"track-1", "track-2", "track-8"}); err != nil {
+		t.Fatalf("changing library playlist: %v", err)
+	}
+	if _, err := svc.GetIndexSummary(ctx); err != nil {
+		t.Fatalf("GetIndexSummary: %v", err)
+	}
+	result, err = svc.SearchIndex(ctx, recommendations.TrackSearch{Query: "vices"})
+	if err != nil {
+		t.Fatalf("SearchIndex: %v", err)
+	}
+	if result.Total != 1 {
+		t.Errorf("found %d tracks added to the library playlist, want 1", result.Total)
+	}
+}
@@ -3,9 +3,11 @@ package recommendations
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/kristofferostlund/recommendli/pkg/ctxhelper"
 	"github.com/kristofferostlund/recommendli/pkg/paginator"
+	"github.com/kristofferostlund/recommendli/pkg/spotifyutil"
 	"github.com/zmb3/spotify"
 )
 
@@ -76,6 +78,18 @@ func (s *SpotifyAdaptor) GetTrack(ctx context.Context, trackID string) (spotify.
 	return *track, nil
 }
 
+// spotifyRetryPolicy retries pages which fail for reasons which are likely to
+// pass, so one failed page doesn't fail e.g. syncing the whole track index.
+// It's only used by the paginators calling Spotify directly, as retrying the
+// ones calling them would multiply the attempts.
+var spotifyRetryPolicy = paginator.RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.5,
+	Retryable:      spotifyutil.IsTransientError,
+}
+
 func spotifyOpts(opts paginator.PageOpts) *spotify.Options {
 	return &spotify.Options{Limit: &opts.Limit, Offset: &opts.Offset}
 }
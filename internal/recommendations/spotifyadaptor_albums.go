@@ -88,7 +88,7 @@ func (s *SpotifyAdaptor) getAlbums(ctx context.Context, albumIDs []string) ([]sp
 		albums []spotify.FullAlbum
 	}
 
-	pgtr := paginator.New(paginator.Parallelism(10), paginator.PageSize(20), paginator.InitialTotalCount(len(albumIDs)))
+	pgtr := paginator.New(paginator.Parallelism(10), paginator.PageSize(20), paginator.InitialTotalCount(len(albumIDs)), paginator.Retry(spotifyRetryPolicy))
 	albumChan := make(chan indexAndAlbums)
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
@@ -141,7 +141,7 @@ func (s *SpotifyAdaptor) ListArtistAlbums(ctx context.Context, artistID string)
 		albums []spotify.SimpleAlbum
 	}
 
-	pgtr := paginator.New(paginator.Parallelism(10))
+	pgtr := paginator.New(paginator.Parallelism(10), paginator.Retry(spotifyRetryPolicy))
 	albumChan := make(chan indexAndAlbums)
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
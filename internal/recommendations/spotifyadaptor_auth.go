@@ -2,59 +2,165 @@ package recommendations
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	uuid "github.com/satori/go.uuid"
 	"github.com/zmb3/spotify"
 	"golang.org/x/oauth2"
 
+	"github.com/kristofferostlund/recommendli/pkg/secretbox"
+	"github.com/kristofferostlund/recommendli/pkg/singleflight"
 	"github.com/kristofferostlund/recommendli/pkg/slogutil"
+	"github.com/kristofferostlund/recommendli/pkg/spotifyutil"
 	"github.com/kristofferostlund/recommendli/pkg/srv"
+	"github.com/kristofferostlund/recommendli/pkg/transport"
 )
 
-type ctxTokenType string
+type ctxAuthType string
 
 const (
-	ctxTokenKey ctxTokenType = "SpotifyClient"
+	ctxAuthKey ctxAuthType = "SpotifyAuth"
 
-	CookieState        = "recommendli_authstate"
-	CookieGoto         = "recommendli_goto"
-	CookieSpotifyToken = "recommendli_spotifytoken"
+	CookieState   = "recommendli_authstate"
+	CookieGoto    = "recommendli_goto"
+	CookieSession = "recommendli_session"
+
+	// legacyCookieSpotifyToken held the plaintext token before tokens were stored server side.
+	legacyCookieSpotifyToken = "recommendli_spotifytoken"
+
+	sessionTTL = 30 * 24 * time.Hour
+
+	spotifyAPIURL      = "https://api.spotify.com/v1/"
+	spotifyAccountsURL = "https://accounts.spotify.com/"
+)
+
+var (
+	ErrNoAuthentication error = errors.New("no authentication found")
+	ErrInvalidCookie    error = errors.New("invalid cookie")
 )
 
-var ErrNoAuthentication error = errors.New("no authentication found")
+type TokenStore interface {
+	GetToken(ctx context.Context, userID string) (*oauth2.Token, bool, error)
+	PutToken(ctx context.Context, userID string, token *oauth2.Token) error
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, session Session) error
+	GetSession(ctx context.Context, sessionID string) (Session, bool, error)
+}
+
+// Session connects the opaque session ID stored in the cookie to the user
+// whose token is kept server side.
+type Session struct {
+	ID        string
+	UserID    string
+	ExpiresAt time.Time
+}
+
+// SpotifyEndpoint is where the Spotify Web API and accounts service are found.
+// It's only changed to run against a mock of them, such as spotifytest.Server.
+type SpotifyEndpoint struct {
+	// APIURL is the base URL of the Web API, including the version, e.g. https://api.spotify.com/v1/.
+	APIURL url.URL
+	// AccountsURL is the base URL of the authorize and token endpoints, e.g. https://accounts.spotify.com/.
+	AccountsURL url.URL
+}
+
+func DefaultSpotifyEndpoint() SpotifyEndpoint {
+	apiURL, _ := url.Parse(spotifyAPIURL)
+	accountsURL, _ := url.Parse(spotifyAccountsURL)
+	return SpotifyEndpoint{APIURL: *apiURL, AccountsURL: *accountsURL}
+}
+
+var spotifyRequestWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "recommendli_spotify_request_wait_seconds",
+	Help:    "Time requests to Spotify waited for the rate limit, by reason.",
+	Buckets: []float64{0, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+}, []string{"reason"})
+
+// NewSpotifyRateLimiter returns a rate limiter allowing each user rps requests per
+// second to Spotify, in bursts of up to burst requests.
+func NewSpotifyRateLimiter(rps float64, burst int) *transport.RateLimiter {
+	return transport.NewRateLimiter(rps, burst, spotifyRequestWaitSeconds)
+}
 
 type AuthAdaptor struct {
-	authenticator              spotify.Authenticator
+	config                     *oauth2.Config
+	httpClient                 *http.Client
+	rateLimiter                *transport.RateLimiter
+	sessions                   SessionStore
+	tokens                     TokenStore
+	cookieKeys                 *secretbox.Keyring
 	redirectURL, uiRedirectURL url.URL
 	secureCookies              bool
+
+	sfRefreshToken singleflight.DoFunc[*oauth2.Token]
 }
 
-func NewSpotifyAuthAdaptor(clientID, clientSecret string, redirectURL, uiRedirectURL url.URL) *AuthAdaptor {
-	authenticator := spotify.NewAuthenticator(
-		redirectURL.String(),
-		spotify.ScopeUserReadPrivate,
-		spotify.ScopePlaylistReadPrivate,
-		spotify.ScopePlaylistModifyPrivate,
-		spotify.ScopePlaylistModifyPublic,
-		spotify.ScopeUserTopRead,
-		spotify.ScopeUserReadCurrentlyPlaying,
-		spotify.ScopeUserReadPlaybackState,
-	)
-	authenticator.SetAuthInfo(clientID, clientSecret)
+// NewSpotifyAuthAdaptor returns an AuthAdaptor which encrypts its cookies with cookieKeys.
+// The clients it creates talk to the Web API at the endpoint, and users are
+// authenticated against its accounts service. Every request to Spotify is sent
+// through base, or http.DefaultTransport when it's nil, which is where requests
+// are recorded or replayed, see transport.Record and transport.Replay.
+// The requests of each user's clients are limited by rateLimiter, unless it's nil.
+// Each user's token is only refreshed by one request at a time, locked with sfLocker.
+func NewSpotifyAuthAdaptor(clientID, clientSecret string, redirectURL, uiRedirectURL url.URL, sessions SessionStore, tokens TokenStore, cookieKeys *secretbox.Keyring, endpoint SpotifyEndpoint, base http.RoundTripper, rateLimiter *transport.RateLimiter, sfLocker singleflight.Locker) *AuthAdaptor {
+	config := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL.String(),
+		Scopes: []string{
+			spotify.ScopeUserReadPrivate,
+			spotify.ScopePlaylistReadPrivate,
+			spotify.ScopePlaylistModifyPrivate,
+			spotify.ScopePlaylistModifyPublic,
+			spotify.ScopeUserTopRead,
+			spotify.ScopeUserReadCurrentlyPlaying,
+			spotify.ScopeUserReadPlaybackState,
+		},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  endpoint.AccountsURL.JoinPath("authorize").String(),
+			TokenURL: endpoint.AccountsURL.JoinPath("api", "token").String(),
+		},
+	}
+
+	// The spotify client's base URL can't be changed, so requests are rebased
+	// onto the configured API URL instead.
+	if !strings.HasSuffix(endpoint.APIURL.Path, "/") {
+		endpoint.APIURL.Path += "/"
+	}
+	rt := base
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if defaults := DefaultSpotifyEndpoint(); endpoint.APIURL != defaults.APIURL {
+		rt = transport.Rebase(rt, defaults.APIURL, endpoint.APIURL)
+	}
+	rt = spotifyutil.ErrorTransport(rt)
 
 	return &AuthAdaptor{
-		authenticator: authenticator,
+		config:        config,
+		httpClient:    &http.Client{Transport: rt},
+		rateLimiter:   rateLimiter,
+		sessions:      sessions,
+		tokens:        tokens,
+		cookieKeys:    cookieKeys,
 		redirectURL:   redirectURL,
 		uiRedirectURL: uiRedirectURL,
 		secureCookies: redirectURL.Scheme == "https",
+
+		sfRefreshToken: singleflight.Prepare[*oauth2.Token](sfLocker, 2*time.Second),
 	}
 }
 
@@ -69,57 +175,40 @@ func (a *AuthAdaptor) UIRedirectPath() string {
 func (a *AuthAdaptor) TokenCallbackHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		c, _ := r.Cookie(CookieState)
-		if c == nil || c.Value == "" {
-			err := fmt.Errorf("missing required cookie %s", CookieState)
-			slog.ErrorContext(ctx, "Missing required cookie", slogutil.Error(err))
-			srv.InternalServerError(w, err)
+		state, err := a.readCookie(r, CookieState)
+		a.clearCookie(w, CookieState)
+		if err != nil {
+			// Don't send the user back to Spotify here, as that could loop forever.
+			slog.WarnContext(ctx, "Rejecting auth state cookie", slogutil.Error(err))
+			srv.JSONError(w, fmt.Errorf("reading cookie %s: %w", CookieState, err), srv.Status(http.StatusBadRequest))
 			return
 		}
 
-		state, err := url.QueryUnescape(c.Value)
-		srv.ClearCookie(w, c)
+		token, err := a.exchange(r, state)
 		if err != nil {
-			slog.ErrorContext(ctx, "Failed to escape state", slog.Any("error", err))
+			slog.ErrorContext(ctx, "Failed to get token", slogutil.Error(err))
 			srv.InternalServerError(w, err)
 			return
 		}
 
-		token, err := a.authenticator.Token(state, r)
+		session, err := a.createSession(ctx, token)
 		if err != nil {
-			slog.ErrorContext(ctx, "Failed to get token", slogutil.Error(err))
+			slog.ErrorContext(ctx, "Failed to create session", slogutil.Error(err))
 			srv.InternalServerError(w, err)
 			return
 		}
 
-		tokenB, err := json.Marshal(token)
-		if err != nil {
-			slog.ErrorContext(ctx, "Failed to marshal token", slogutil.Error(err))
+		if err := a.setCookie(w, CookieSession, session.ID, session.ExpiresAt); err != nil {
+			slog.ErrorContext(ctx, "Failed to set session cookie", slogutil.Error(err))
 			srv.InternalServerError(w, err)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     CookieSpotifyToken,
-			Value:    base64.StdEncoding.EncodeToString(tokenB),
-			Expires:  token.Expiry,
-			Path:     "/",
-			Secure:   a.secureCookies,
-			HttpOnly: true,
-			// @TODO: Read up on what cookie method to use so this is actually secure
-			SameSite: http.SameSiteLaxMode,
-		})
-
-		gc, _ := r.Cookie(CookieGoto)
-		if gc != nil && gc.Value != "" {
-			redirectTo, err := url.QueryUnescape(gc.Value)
-			srv.ClearCookie(w, gc)
-			if err != nil {
-				slog.ErrorContext(ctx, "Failed to get token", slogutil.Error(err))
-				srv.InternalServerError(w, err)
-				return
-			}
-
+		redirectTo, err := a.readCookie(r, CookieGoto)
+		a.clearCookie(w, CookieGoto)
+		if err != nil && !errors.Is(err, http.ErrNoCookie) {
+			slog.WarnContext(ctx, "Ignoring invalid goto cookie", slogutil.Error(err))
+		} else if err == nil && redirectTo != "" {
 			http.Redirect(w, r, redirectTo, http.StatusTemporaryRedirect)
 			return
 		}
@@ -145,66 +234,285 @@ func (a *AuthAdaptor) Middleware() func(h http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
-			c, _ := r.Cookie(CookieSpotifyToken)
-			if c != nil && c.Value != "" {
-				token := &oauth2.Token{}
-				decoded, _ := base64.StdEncoding.DecodeString(c.Value)
-				err := json.Unmarshal(decoded, token)
-				if err != nil {
-					slog.WarnContext(ctx, "Failed to unmarshal token", slogutil.Error(err))
-					a.redirect(w, r, r.URL.String())
-					return
+			if c, _ := r.Cookie(legacyCookieSpotifyToken); c != nil {
+				a.clearCookie(w, legacyCookieSpotifyToken)
+			}
+
+			sessionID, err := a.readCookie(r, CookieSession)
+			if err != nil {
+				if !errors.Is(err, http.ErrNoCookie) {
+					slog.WarnContext(ctx, "Rejecting session cookie", slogutil.Error(err))
 				}
-				if !token.Valid() {
+				// Clearing the cookie makes sure the new session cookie replaces it.
+				a.clearCookie(w, CookieSession)
+				a.redirect(w, r, r.URL.String())
+				return
+			}
+
+			session, exists, err := a.sessions.GetSession(ctx, sessionID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to get session", slogutil.Error(err))
+				srv.InternalServerError(w, err)
+				return
+			}
+			if !exists {
+				a.clearCookie(w, CookieSession)
+				a.redirect(w, r, r.URL.String())
+				return
+			}
+
+			token, exists, err := a.tokens.GetToken(ctx, session.UserID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to get token", slogutil.Error(err), slog.String("user", session.UserID))
+				srv.InternalServerError(w, err)
+				return
+			}
+			if !exists {
+				a.redirect(w, r, r.URL.String())
+				return
+			}
+			if !token.Valid() {
+				// Refresh up front, so that a revoked refresh token sends the user
+				// through the OAuth flow rather than failing the request.
+				if token, err = a.tokenSource(a.withUserHTTPClient(ctx, session.UserID), session.UserID, token).Token(); err != nil {
+					slog.WarnContext(ctx, "Failed to refresh token", slogutil.Error(err), slog.String("user", session.UserID))
 					a.redirect(w, r, r.URL.String())
 					return
 				}
-
-				r = r.WithContext(context.WithValue(r.Context(), ctxTokenKey, token))
-				h.ServeHTTP(w, r)
-				return
 			}
 
-			a.redirect(w, r, r.URL.String())
+			r = r.WithContext(context.WithValue(ctx, ctxAuthKey, userToken{userID: session.UserID, token: token}))
+			h.ServeHTTP(w, r)
 		})
 	}
 }
 
+type userToken struct {
+	userID string
+	token  *oauth2.Token
+}
+
+// UserID returns the Spotify ID of the user signed in on the request.
+func (a *AuthAdaptor) UserID(r *http.Request) (string, error) {
+	ut, ok := r.Context().Value(ctxAuthKey).(userToken)
+	if !ok {
+		return "", ErrNoAuthentication
+	}
+	return ut.userID, nil
+}
+
 func (a *AuthAdaptor) GetClient(r *http.Request) (spotify.Client, error) {
-	token, ok := r.Context().Value(ctxTokenKey).(*oauth2.Token)
+	ctx := r.Context()
+	ut, ok := ctx.Value(ctxAuthKey).(userToken)
 	if !ok {
 		return spotify.Client{}, ErrNoAuthentication
 	}
-	client := a.authenticator.NewClient(token)
+	return a.NewClient(ctx, ut.userID, ut.token), nil
+}
+
+// NewClient returns a client for the user's token. The token is refreshed when it
+// expires and the refreshed token is stored, as Spotify may rotate refresh tokens.
+func (a *AuthAdaptor) NewClient(ctx context.Context, userID string, token *oauth2.Token) spotify.Client {
+	ctx = a.withUserHTTPClient(ctx, userID)
+	client := spotify.NewClient(oauth2.NewClient(ctx, a.tokenSource(ctx, userID, token)))
 	client.AutoRetry = true
-	return client, nil
+	return client
+}
+
+// tokenSource returns a source of the user's token which refreshes and stores it
+// once it expires. Tokens are refreshed with the HTTP client of ctx.
+func (a *AuthAdaptor) tokenSource(ctx context.Context, userID string, token *oauth2.Token) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(token, &storingTokenSource{
+		// The client may outlive the request, e.g. in background jobs.
+		ctx:       context.WithoutCancel(ctx),
+		userID:    userID,
+		tokens:    a.tokens,
+		config:    a.config,
+		sfRefresh: a.sfRefreshToken,
+		last:      token,
+	})
+}
+
+// withHTTPClient makes oauth2 use the adaptor's HTTP client, both for the clients
+// it returns and for talking to the token endpoint.
+func (a *AuthAdaptor) withHTTPClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, a.httpClient)
+}
+
+// withUserHTTPClient is withHTTPClient with the user's requests rate limited.
+func (a *AuthAdaptor) withUserHTTPClient(ctx context.Context, userID string) context.Context {
+	httpClient := a.httpClient
+	if a.rateLimiter != nil {
+		// The paginators fan out, so a single request of ours can make many to Spotify.
+		httpClient = &http.Client{Transport: a.rateLimiter.Transport(userID, a.httpClient.Transport)}
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
+
+// storingTokenSource refreshes the token and stores every new token.
+type storingTokenSource struct {
+	ctx       context.Context
+	userID    string
+	tokens    TokenStore
+	config    *oauth2.Config
+	sfRefresh singleflight.DoFunc[*oauth2.Token]
+
+	mux  sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *storingTokenSource) Token() (*oauth2.Token, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// Spotify may rotate the refresh token, so concurrent refreshes could store a
+	// refresh token which has already been replaced. Refreshes are therefore done
+	// one at a time per user, starting from the latest stored token.
+	key := fmt.Sprintf("refreshToken:%s", s.userID)
+	token, err := s.sfRefresh(s.ctx, key, func(ctx context.Context) (*oauth2.Token, error) {
+		stored, exists, err := s.tokens.GetToken(ctx, s.userID)
+		if err != nil {
+			return nil, fmt.Errorf("getting stored token: %w", err)
+		}
+		if !exists {
+			stored = s.last
+		}
+		if stored.Valid() {
+			// Refreshed by someone else while waiting for the lock.
+			return stored, nil
+		}
+
+		token, err := s.config.TokenSource(ctx, stored).Token()
+		if err != nil {
+			return nil, err
+		}
+		if token.AccessToken == stored.AccessToken {
+			return token, nil
+		}
+		if err := s.tokens.PutToken(ctx, s.userID, token); err != nil {
+			return nil, fmt.Errorf("storing refreshed token: %w", err)
+		}
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.last = token
+	return token, nil
+}
+
+// exchange does the same checks as spotify.Authenticator.Token before exchanging
+// the code for a token.
+func (a *AuthAdaptor) exchange(r *http.Request, state string) (*oauth2.Token, error) {
+	values := r.URL.Query()
+	if e := values.Get("error"); e != "" {
+		return nil, fmt.Errorf("spotify: auth failed - %s", e)
+	}
+	code := values.Get("code")
+	if code == "" {
+		return nil, errors.New("spotify: didn't get access code")
+	}
+	if actualState := values.Get("state"); actualState != state {
+		return nil, errors.New("spotify: redirect state parameter doesn't match")
+	}
+	return a.config.Exchange(a.withHTTPClient(r.Context()), code)
+}
+
+func (a *AuthAdaptor) createSession(ctx context.Context, token *oauth2.Token) (Session, error) {
+	client := spotify.NewClient(a.config.Client(a.withHTTPClient(ctx), token))
+	usr, err := client.CurrentUser()
+	if err != nil {
+		return Session{}, fmt.Errorf("getting current user: %w", err)
+	}
+	if err := a.tokens.PutToken(ctx, usr.ID, token); err != nil {
+		return Session{}, fmt.Errorf("storing token: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Session{}, fmt.Errorf("generating session id: %w", err)
+	}
+	session := Session{
+		ID:        base64.RawURLEncoding.EncodeToString(b),
+		UserID:    usr.ID,
+		ExpiresAt: time.Now().Add(sessionTTL),
+	}
+	if err := a.sessions.CreateSession(ctx, session); err != nil {
+		return Session{}, fmt.Errorf("creating session: %w", err)
+	}
+	return session, nil
 }
 
 func (a *AuthAdaptor) redirect(w http.ResponseWriter, r *http.Request, redirectBackTo string) {
 	state := uuid.NewV4().String()
 
+	expires := time.Now().Add(time.Hour)
+	if err := a.setCookie(w, CookieState, state, expires); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set state cookie", slogutil.Error(err))
+		srv.InternalServerError(w, err)
+		return
+	}
+	if err := a.setCookie(w, CookieGoto, redirectBackTo, expires); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set goto cookie", slogutil.Error(err))
+		srv.InternalServerError(w, err)
+		return
+	}
+
+	authURL := a.config.AuthCodeURL(state)
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// setCookie sets an encrypted cookie. The cookie name is sealed along with the
+// value so that a value can't be moved from one cookie to another.
+func (a *AuthAdaptor) setCookie(w http.ResponseWriter, name, value string, expires time.Time) error {
+	sealed, err := a.cookieKeys.Seal([]byte(name + "=" + value))
+	if err != nil {
+		return fmt.Errorf("encrypting cookie %s: %w", name, err)
+	}
 	http.SetCookie(w, &http.Cookie{
-		Name:     CookieState,
-		Value:    url.QueryEscape(state),
-		Expires:  time.Now().Add(time.Hour),
+		Name:     name,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		Expires:  expires,
 		Path:     "/",
 		Secure:   a.secureCookies,
 		HttpOnly: true,
 		// @TODO: Read up on what cookie method to use so this is actually secure
 		SameSite: http.SameSiteLaxMode,
 	})
+	return nil
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     CookieGoto,
-		Value:    url.QueryEscape(redirectBackTo),
-		Expires:  time.Now().Add(time.Hour),
+// readCookie returns the decrypted value of the cookie, http.ErrNoCookie if it isn't
+// set, or ErrInvalidCookie if it's been tampered with or was encrypted with an unknown key.
+func (a *AuthAdaptor) readCookie(r *http.Request, name string) (string, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	if c.Value == "" {
+		return "", http.ErrNoCookie
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	b, err := a.cookieKeys.Open(sealed)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	value, ok := strings.CutPrefix(string(b), name+"=")
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+	return value, nil
+}
+
+func (a *AuthAdaptor) clearCookie(w http.ResponseWriter, name string) {
+	srv.ClearCookie(w, &http.Cookie{
+		Name:     name,
 		Path:     "/",
 		Secure:   a.secureCookies,
 		HttpOnly: true,
-		// @TODO: Read up on what cookie method to use so this is actually secure
 		SameSite: http.SameSiteLaxMode,
 	})
-
-	authURL := a.authenticator.AuthURL(state)
-	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 }
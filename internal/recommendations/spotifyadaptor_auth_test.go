@@ -0,0 +1,125 @@
+package recommendations_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+	"github.com/kristofferostlund/recommendli/internal/sqlite"
+	"github.com/kristofferostlund/recommendli/pkg/secretbox"
+)
+
+type countingTransport struct {
+	calls atomic.Int32
+	next  http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls.Add(1)
+	return t.next.RoundTrip(req)
+}
+
+// rotatingTokenServer is a token endpoint which rotates the refresh token on every
+// refresh, and rejects refresh tokens which have been replaced.
+func rotatingTokenServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	mux := &sync.Mutex{}
+	refreshes := &atomic.Int32{}
+	current := "refresh-0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Widens the window for concurrent refreshes.
+		time.Sleep(50 * time.Millisecond)
+
+		mux.Lock()
+		defer mux.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/token" || r.PostFormValue("refresh_token") != current {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		n := refreshes.Add(1)
+		current = fmt.Sprintf("refresh-%d", n)
+		fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"Bearer","expires_in":3600,"refresh_token":%q}`, n, current)
+	}))
+	t.Cleanup(server.Close)
+	return server, refreshes
+}
+
+func TestAuthAdaptorRefreshesTokenOnce(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	server, refreshes := rotatingTokenServer(t)
+
+	box, err := secretbox.New(newTestKey(t))
+	if err != nil {
+		t.Fatalf("creating secretbox: %v", err)
+	}
+	cookieKeys, err := secretbox.NewKeyring(newTestKey(t))
+	if err != nil {
+		t.Fatalf("creating keyring: %v", err)
+	}
+	tokens := sqlite.NewTokenStore(db, box)
+
+	endpoint := recommendations.DefaultSpotifyEndpoint()
+	accountsURL, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("parsing server URL: %v", err)
+	}
+	endpoint.AccountsURL = *accountsURL
+	base := &countingTransport{next: http.DefaultTransport}
+	redirectURL, uiRedirectURL := url.URL{Scheme: "http", Host: "localhost", Path: "/callback"}, url.URL{Scheme: "http", Host: "localhost", Path: "/ui-redirect"}
+	auth := recommendations.NewSpotifyAuthAdaptor("client-id", "client-secret", redirectURL, uiRedirectURL, sqlite.NewSessionStore(db), tokens, cookieKeys, endpoint, base, nil, sqlite.NewLocker(db))
+
+	expired := oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", TokenType: "Bearer", Expiry: time.Now().Add(-time.Hour)}
+	if err := tokens.PutToken(ctx, "user", &expired); err != nil {
+		t.Fatalf("PutToken: %v", err)
+	}
+
+	// Every client starts out with the expired token, as requests which started
+	// before the token was refreshed do.
+	const clients = 5
+	got := make([]*oauth2.Token, clients)
+	errs := make([]error, clients)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := expired
+			client := auth.NewClient(ctx, "user", &token)
+			got[i], errs[i] = client.Token()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range got {
+		if errs[i] != nil {
+			t.Fatalf("client %d failed to refresh the token: %v", i, errs[i])
+		}
+		if got[i].AccessToken != "access-1" {
+			t.Errorf("client %d got access token %q, want %q", i, got[i].AccessToken, "access-1")
+		}
+	}
+	if n := refreshes.Load(); n != 1 {
+		t.Errorf("token was refreshed %d times, want 1", n)
+	}
+	if base.calls.Load() == 0 {
+		t.Errorf("token was refreshed without the adaptor's transport")
+	}
+	stored, _, err := tokens.GetToken(ctx, "user")
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if stored.RefreshToken != "refresh-1" {
+		t.Errorf("stored refresh token = %q, want %q", stored.RefreshToken, "refresh-1")
+	}
+}
@@ -114,6 +114,7 @@ func (s *SpotifyAdaptor) PopulatePlaylists(ctx context.Context, simplePlaylists
 
 	playlists := make([]spotify.FullPlaylist, 0, len(simplePlaylists))
 	for i, cached := range cachedPlaylists {
+		reportProgress(ctx, Progress{Stage: StagePopulatingPlaylists, Detail: simplePlaylists[i].Name, Current: i + 1, Total: len(simplePlaylists)})
 		// If it's populated and not outdated, use it
 		if cached.SnapshotID != "" && !spotifyutil.SimplePlaylistHasChanged(cached.SimplePlaylist, simplePlaylists[i]) {
 			playlists = append(playlists, cached)
@@ -176,7 +177,7 @@ func (s *SpotifyAdaptor) getPlaylist(ctx context.Context, playlistID string) (sp
 			tracks []spotify.PlaylistTrack
 		}
 
-		pgtr := paginator.New(paginator.InitialOffset(len(p.Tracks.Tracks)), paginator.Parallelism(10))
+		pgtr := paginator.New(paginator.InitialOffset(len(p.Tracks.Tracks)), paginator.Parallelism(10), paginator.Retry(spotifyRetryPolicy))
 		itChan := make(chan indexAndTracks)
 		g, ctx := errgroup.WithContext(ctx)
 		g.Go(func() error {
@@ -188,6 +189,7 @@ func (s *SpotifyAdaptor) getPlaylist(ctx context.Context, playlistID string) (sp
 				}
 				itChan <- indexAndTracks{i, page.Tracks}
 				slog.Debug("listing playlist tracks", "playlist", p.Name, "counter", i, "offset", page.Offset, "total", page.Total)
+				reportProgress(ctx, Progress{Stage: StageFetchingPlaylistTracks, Detail: p.Name, Current: page.Offset + len(page.Tracks), Total: page.Total})
 				return next(page.Total), nil
 			})
 		})
@@ -217,7 +219,7 @@ func (s *SpotifyAdaptor) listPlaylists(ctx context.Context, userID string) ([]sp
 		playlists []spotify.SimplePlaylist
 	}
 
-	pgtr := paginator.New(paginator.Parallelism(10))
+	pgtr := paginator.New(paginator.Parallelism(10), paginator.Retry(spotifyRetryPolicy))
 	ipChan := make(chan indexAndPlaylists)
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
@@ -0,0 +1,196 @@
+package spotifytest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+func User(id string) spotify.User {
+	return spotify.User{ID: id, DisplayName: id, URI: spotify.URI("spotify:user:" + id)}
+}
+
+func Artist(id, name string) spotify.SimpleArtist {
+	return spotify.SimpleArtist{ID: spotify.ID(id), Name: name, URI: spotify.URI("spotify:artist:" + id)}
+}
+
+type TrackOption func(t *spotify.FullTrack)
+
+func Popularity(popularity int) TrackOption {
+	return func(t *spotify.FullTrack) { t.Popularity = popularity }
+}
+
+func Duration(d time.Duration) TrackOption {
+	return func(t *spotify.FullTrack) { t.Duration = int(d.Milliseconds()) }
+}
+
+func ISRC(isrc string) TrackOption {
+	return func(t *spotify.FullTrack) { t.ExternalIDs = map[string]string{"isrc": isrc} }
+}
+
+// Featuring adds artists to the track besides the album's artists.
+func Featuring(artists ...spotify.SimpleArtist) TrackOption {
+	return func(t *spotify.FullTrack) { t.Artists = append(t.Artists, artists...) }
+}
+
+// AlbumBuilder builds an album along with its tracks. Tracks are by the album's
+// artists unless they're given other ones.
+type AlbumBuilder struct {
+	album  spotify.FullAlbum
+	tracks []spotify.FullTrack
+}
+
+// NewAlbum starts building an album, which defaults to being of the "album" type
+// released 2020-01-01.
+func NewAlbum(id, name string) *AlbumBuilder {
+	return &AlbumBuilder{album: spotify.FullAlbum{SimpleAlbum: spotify.SimpleAlbum{
+		ID:                   spotify.ID(id),
+		Name:                 name,
+		URI:                  spotify.URI("spotify:album:" + id),
+		AlbumType:            "album",
+		ReleaseDate:          "2020-01-01",
+		ReleaseDatePrecision: "day",
+	}}}
+}
+
+func (b *AlbumBuilder) By(artists ...spotify.SimpleArtist) *AlbumBuilder {
+	b.album.Artists = artists
+	return b
+}
+
+// Type sets the album type, one of "album", "single" or "compilation".
+func (b *AlbumBuilder) Type(albumType string) *AlbumBuilder {
+	b.album.AlbumType = albumType
+	return b
+}
+
+// Released sets the release date, formatted like Spotify's "2006-01-02", "2006-01" or "2006".
+func (b *AlbumBuilder) Released(date string) *AlbumBuilder {
+	b.album.ReleaseDate = date
+	switch len(date) {
+	case len("2006"):
+		b.album.ReleaseDatePrecision = "year"
+	case len("2006-01"):
+		b.album.ReleaseDatePrecision = "month"
+	default:
+		b.album.ReleaseDatePrecision = "day"
+	}
+	return b
+}
+
+func (b *AlbumBuilder) Track(id, name string, opts ...TrackOption) *AlbumBuilder {
+	track := spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:          spotify.ID(id),
+			Name:        name,
+			URI:         spotify.URI("spotify:track:" + id),
+			Duration:    int((3 * time.Minute).Milliseconds()),
+			TrackNumber: len(b.tracks) + 1,
+			DiscNumber:  1,
+		},
+	}
+	for _, opt := range opts {
+		opt(&track)
+	}
+	b.tracks = append(b.tracks, track)
+	return b
+}
+
+// Build returns the album and its tracks.
+func (b *AlbumBuilder) Build() (spotify.FullAlbum, []spotify.FullTrack) {
+	album := b.album
+	album.Tracks.Tracks = make([]spotify.SimpleTrack, 0, len(b.tracks))
+	tracks := make([]spotify.FullTrack, 0, len(b.tracks))
+	for _, t := range b.tracks {
+		t.Artists = append(append([]spotify.SimpleArtist(nil), album.Artists...), t.Artists...)
+		t.Album = album.SimpleAlbum
+		album.Tracks.Tracks = append(album.Tracks.Tracks, t.SimpleTrack)
+		tracks = append(tracks, t)
+	}
+	album.Tracks.Total = len(tracks)
+	album.Tracks.Limit = len(tracks)
+	return album, tracks
+}
+
+// AddTo adds the album and its tracks to the provider.
+func (b *AlbumBuilder) AddTo(p *Provider) spotify.FullAlbum {
+	album, tracks := b.Build()
+	p.AddAlbum(album)
+	for _, t := range tracks {
+		p.AddTrack(t)
+	}
+	return album
+}
+
+// AddUser adds the user, the first user added becomes the current user.
+func (p *Provider) AddUser(user spotify.User) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.users[user.ID] = user
+	if p.currentUserID == "" {
+		p.currentUserID = user.ID
+	}
+}
+
+// SetCurrentUser sets the user the provider acts as, as if signed in as them.
+func (p *Provider) SetCurrentUser(userID string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.currentUserID = userID
+}
+
+// AddAlbum adds the album along with its tracks. Tracks get popularity and the
+// like from AddTrack, which can be called afterwards.
+func (p *Provider) AddAlbum(album spotify.FullAlbum) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, exists := p.albums[album.ID]; !exists {
+		p.albumOrder = append(p.albumOrder, album.ID)
+	}
+	p.albums[album.ID] = album
+	for _, t := range album.Tracks.Tracks {
+		if _, exists := p.tracks[t.ID]; !exists {
+			p.tracks[t.ID] = spotify.FullTrack{SimpleTrack: t, Album: album.SimpleAlbum}
+		}
+	}
+}
+
+func (p *Provider) AddTrack(track spotify.FullTrack) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.tracks[track.ID] = track
+}
+
+// AddPlaylist adds a playlist owned by the user with the tracks, which must have
+// been added already. It panics if they haven't, as it's meant for seeding tests.
+func (p *Provider) AddPlaylist(ownerID, name string, trackIDs ...string) spotify.FullPlaylist {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, ok := p.users[ownerID]; !ok {
+		panic(fmt.Sprintf("spotifytest: adding playlist %s for unknown user %s", name, ownerID))
+	}
+	if err := p.checkTracksExist(trackIDs); err != nil {
+		panic(fmt.Sprintf("spotifytest: adding playlist %s: %s", name, err))
+	}
+	pl := p.addPlaylist(ownerID, name)
+	p.appendTracks(pl, trackIDs)
+	return copyPlaylist(*pl)
+}
+
+// Play makes the track the currently playing one, it panics if it hasn't been added.
+func (p *Provider) Play(trackID string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	track, ok := p.tracks[spotify.ID(trackID)]
+	if !ok {
+		panic(fmt.Sprintf("spotifytest: playing unknown track %s", trackID))
+	}
+	p.playing = &track
+}
+
+func (p *Provider) Pause() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.playing = nil
+}
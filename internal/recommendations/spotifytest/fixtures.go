@@ -0,0 +1,101 @@
+package spotifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zmb3/spotify"
+)
+
+// Fixture seeds a Provider. Albums and tracks use the Spotify Web API's own JSON
+// format, so responses can be copied from the API. Tracks of albums are added
+// along with them and only need to be listed in Tracks for fields such as
+// popularity which aren't part of the album's tracks.
+//
+//	{
+//	  "current_user": "kristoffer",
+//	  "users": [{"id": "kristoffer"}],
+//	  "albums": [{"id": "album-1", "name": "Reign in Blood", "album_type": "album", "artists": [...], "tracks": {"items": [...]}}],
+//	  "tracks": [{"id": "track-1", "name": "Angel of Death", "popularity": 70, ...}],
+//	  "playlists": [{"owner": "kristoffer", "name": "Metal 12", "tracks": ["track-1"]}],
+//	  "currently_playing": "track-1"
+//	}
+type Fixture struct {
+	CurrentUser      string              `json:"current_user"`
+	Users            []spotify.User      `json:"users"`
+	Albums           []spotify.FullAlbum `json:"albums"`
+	Tracks           []spotify.FullTrack `json:"tracks"`
+	Playlists        []FixturePlaylist   `json:"playlists"`
+	CurrentlyPlaying string              `json:"currently_playing"`
+}
+
+type FixturePlaylist struct {
+	Owner  string   `json:"owner"`
+	Name   string   `json:"name"`
+	Tracks []string `json:"tracks"`
+}
+
+// LoadFixture returns a Provider seeded from the JSON fixture at path.
+func LoadFixture(path string) (*Provider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening fixture: %w", err)
+	}
+	defer f.Close()
+
+	p, err := ReadFixture(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func ReadFixture(r io.Reader) (*Provider, error) {
+	var fixture Fixture
+	if err := json.NewDecoder(r).Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("decoding fixture: %w", err)
+	}
+	return fixture.Provider()
+}
+
+// Provider returns a Provider seeded with the fixture.
+func (f Fixture) Provider() (*Provider, error) {
+	p := New()
+	for _, usr := range f.Users {
+		p.AddUser(usr)
+	}
+	if f.CurrentUser != "" {
+		if _, ok := p.users[f.CurrentUser]; !ok {
+			return nil, fmt.Errorf("current user %s isn't one of the users", f.CurrentUser)
+		}
+		p.SetCurrentUser(f.CurrentUser)
+	}
+	for _, album := range f.Albums {
+		p.AddAlbum(album)
+	}
+	for _, track := range f.Tracks {
+		// Tracks listed on one of the albums belong to it unless they say otherwise.
+		if existing, ok := p.tracks[track.ID]; ok && track.Album.ID == "" {
+			track.Album = existing.Album
+		}
+		p.AddTrack(track)
+	}
+	for _, pl := range f.Playlists {
+		if _, ok := p.users[pl.Owner]; !ok {
+			return nil, fmt.Errorf("playlist %s is owned by unknown user %s", pl.Name, pl.Owner)
+		}
+		if err := p.checkTracksExist(pl.Tracks); err != nil {
+			return nil, fmt.Errorf("playlist %s: %w", pl.Name, err)
+		}
+		p.AddPlaylist(pl.Owner, pl.Name, pl.Tracks...)
+	}
+	if f.CurrentlyPlaying != "" {
+		if _, ok := p.tracks[spotify.ID(f.CurrentlyPlaying)]; !ok {
+			return nil, fmt.Errorf("currently playing track %s doesn't exist", f.CurrentlyPlaying)
+		}
+		p.Play(f.CurrentlyPlaying)
+	}
+	return p, nil
+}
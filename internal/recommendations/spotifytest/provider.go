@@ -0,0 +1,417 @@
+// Package spotifytest provides an in-memory recommendations.SpotifyProvider for
+// tests which shouldn't reach Spotify.
+//
+// A Provider is seeded using the builders, e.g.
+//
+//	p := spotifytest.New()
+//	p.AddUser(spotifytest.User("kristoffer"))
+//	spotifytest.NewAlbum("album-1", "Reign in Blood").By(spotifytest.Artist("artist-1", "Slayer")).Track("track-1", "Angel of Death").AddTo(p)
+//	p.AddPlaylist("kristoffer", "Metal 12", "track-1")
+//
+// or from a JSON fixture, see LoadFixture.
+package spotifytest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+	"github.com/kristofferostlund/recommendli/pkg/ctxhelper"
+	"github.com/zmb3/spotify"
+)
+
+var _ recommendations.SpotifyProvider = (*Provider)(nil)
+
+// Provider keeps users, tracks, albums and playlists in memory and behaves like
+// recommendations.SpotifyAdaptor does against the Web API: playlists get a new
+// snapshot ID whenever they change, only the current user's own playlists can be
+// changed and missing resources result in a spotify.Error with a 404 status.
+//
+// It's safe for concurrent use.
+type Provider struct {
+	mux sync.Mutex
+
+	currentUserID string
+	users         map[string]spotify.User
+	tracks        map[spotify.ID]spotify.FullTrack
+	albums        map[spotify.ID]spotify.FullAlbum
+	albumOrder    []spotify.ID
+	playlists     map[spotify.ID]*spotify.FullPlaylist
+	playlistOrder []spotify.ID
+	playing       *spotify.FullTrack
+
+	sequence int
+	calls    map[string]int
+	now      func() time.Time
+}
+
+func New() *Provider {
+	return &Provider{
+		users:     make(map[string]spotify.User),
+		tracks:    make(map[spotify.ID]spotify.FullTrack),
+		albums:    make(map[spotify.ID]spotify.FullAlbum),
+		playlists: make(map[spotify.ID]*spotify.FullPlaylist),
+		calls:     make(map[string]int),
+		now:       time.Now,
+	}
+}
+
+// SetClock replaces the function used for the time tracks are added to playlists.
+func (p *Provider) SetClock(now func() time.Time) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.now = now
+}
+
+// Calls returns the number of times the SpotifyProvider method has been called,
+// which is useful for asserting what is cached.
+func (p *Provider) Calls(method string) int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.calls[method]
+}
+
+func (p *Provider) called(method string) {
+	p.calls[method]++
+}
+
+func (p *Provider) nextID(kind string) spotify.ID {
+	p.sequence++
+	return spotify.ID(fmt.Sprintf("%s-%d", kind, p.sequence))
+}
+
+func (p *Provider) nextSnapshotID() string {
+	p.sequence++
+	return fmt.Sprintf("snapshot-%d", p.sequence)
+}
+
+func notFound(kind string, id string) error {
+	return spotify.Error{Message: fmt.Sprintf("%s %s not found", kind, id), Status: http.StatusNotFound}
+}
+
+func forbidden(message string) error {
+	return spotify.Error{Message: message, Status: http.StatusForbidden}
+}
+
+func (p *Provider) CurrentUser(ctx context.Context) (spotify.User, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.User{}, fmt.Errorf("getting current user: %w", err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("CurrentUser")
+
+	usr, ok := p.users[p.currentUserID]
+	if !ok {
+		return spotify.User{}, fmt.Errorf("getting current user: %w", spotify.Error{Message: "no current user", Status: http.StatusUnauthorized})
+	}
+	return usr, nil
+}
+
+func (p *Provider) CurrentTrack(ctx context.Context) (spotify.FullTrack, bool, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.FullTrack{}, false, fmt.Errorf("getting currently playing track: %w", err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("CurrentTrack")
+
+	if p.playing == nil {
+		return spotify.FullTrack{}, false, nil
+	}
+	return *p.playing, true, nil
+}
+
+func (p *Provider) GetTrack(ctx context.Context, trackID string) (spotify.FullTrack, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.FullTrack{}, fmt.Errorf("getting track %s: %w", trackID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("GetTrack")
+
+	track, ok := p.tracks[spotify.ID(trackID)]
+	if !ok {
+		return spotify.FullTrack{}, fmt.Errorf("getting track %s: %w", trackID, notFound("track", trackID))
+	}
+	return track, nil
+}
+
+func (p *Provider) GetAlbum(ctx context.Context, albumID string) (spotify.FullAlbum, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.FullAlbum{}, fmt.Errorf("getting album %s: %w", albumID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("GetAlbum")
+
+	album, ok := p.albums[spotify.ID(albumID)]
+	if !ok {
+		return spotify.FullAlbum{}, fmt.Errorf("getting album %s: %w", albumID, notFound("album", albumID))
+	}
+	return album, nil
+}
+
+func (p *Provider) GetAlbums(ctx context.Context, albumIDs []string) ([]spotify.FullAlbum, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return nil, fmt.Errorf("getting albums: %w", err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("GetAlbums")
+
+	albums := make([]spotify.FullAlbum, 0, len(albumIDs))
+	for _, id := range albumIDs {
+		album, ok := p.albums[spotify.ID(id)]
+		if !ok {
+			return nil, fmt.Errorf("album %s doesn't exist", id)
+		}
+		albums = append(albums, album)
+	}
+	return albums, nil
+}
+
+// ListArtistAlbums lists the albums the artist is credited on, newest first like
+// Spotify does.
+func (p *Provider) ListArtistAlbums(ctx context.Context, artistID string) ([]spotify.SimpleAlbum, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return nil, fmt.Errorf("listing albums for artist %s: %w", artistID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("ListArtistAlbums")
+
+	albums := make([]spotify.SimpleAlbum, 0)
+	for _, id := range p.albumOrder {
+		album := p.albums[id]
+		for _, a := range album.Artists {
+			if a.ID.String() == artistID {
+				simple := album.SimpleAlbum
+				simple.AlbumGroup = simple.AlbumType
+				albums = append(albums, simple)
+				break
+			}
+		}
+	}
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].ReleaseDateTime().After(albums[j].ReleaseDateTime())
+	})
+	return albums, nil
+}
+
+// ListPlaylists lists the playlists owned by the user in the order they were added.
+func (p *Provider) ListPlaylists(ctx context.Context, userID string) ([]spotify.SimplePlaylist, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return nil, fmt.Errorf("listing playlists for user %s: %w", userID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("ListPlaylists")
+
+	if _, ok := p.users[userID]; !ok {
+		return nil, fmt.Errorf("listing playlists for user %s: %w", userID, notFound("user", userID))
+	}
+	playlists := make([]spotify.SimplePlaylist, 0)
+	for _, id := range p.playlistOrder {
+		if pl := p.playlists[id]; pl.Owner.ID == userID {
+			playlists = append(playlists, pl.SimplePlaylist)
+		}
+	}
+	return playlists, nil
+}
+
+func (p *Provider) GetPlaylist(ctx context.Context, playlistID string) (spotify.FullPlaylist, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("getting playlist %s: %w", playlistID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("GetPlaylist")
+
+	return p.getPlaylist(playlistID)
+}
+
+func (p *Provider) getPlaylist(playlistID string) (spotify.FullPlaylist, error) {
+	pl, ok := p.playlists[spotify.ID(playlistID)]
+	if !ok {
+		return spotify.FullPlaylist{}, fmt.Errorf("getting playlist %s: %w", playlistID, notFound("playlist", playlistID))
+	}
+	return copyPlaylist(*pl), nil
+}
+
+func (p *Provider) PopulatePlaylists(ctx context.Context, simplePlaylists []spotify.SimplePlaylist) ([]spotify.FullPlaylist, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return nil, fmt.Errorf("populating playlists: %w", err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("PopulatePlaylists")
+
+	playlists := make([]spotify.FullPlaylist, 0, len(simplePlaylists))
+	for _, sp := range simplePlaylists {
+		pl, err := p.getPlaylist(sp.ID.String())
+		if err != nil {
+			return nil, fmt.Errorf("populating playlists: %w", err)
+		}
+		playlists = append(playlists, pl)
+	}
+	return playlists, nil
+}
+
+// CreatePlaylist creates a playlist, which like on Spotify can only be done for
+// the current user.
+func (p *Provider) CreatePlaylist(ctx context.Context, userID, name string, trackIDs []string) (spotify.FullPlaylist, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("creating playlist %s for user %s: %w", name, userID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("CreatePlaylist")
+
+	if userID != p.currentUserID {
+		return spotify.FullPlaylist{}, fmt.Errorf("creating playlist %s for user %s: %w", name, userID, forbidden("cannot create playlists for other users"))
+	}
+	if err := p.checkTracksExist(trackIDs); err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("creating playlist %s for user %s: %w", name, userID, err)
+	}
+	pl := p.addPlaylist(userID, name)
+	p.appendTracks(pl, trackIDs)
+	return copyPlaylist(*pl), nil
+}
+
+// SetPlaylistTracks adds the tracks to the end of the playlist. Like the
+// SpotifyAdaptor it doesn't remove the existing tracks, that's up to TruncatePlaylist.
+func (p *Provider) SetPlaylistTracks(ctx context.Context, playlistID string, trackIDs []string) (spotify.FullPlaylist, error) {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("seting tracks for playlist %s: %w", playlistID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("SetPlaylistTracks")
+
+	pl, err := p.modifiablePlaylist(playlistID)
+	if err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("adding tracks to playlist %s: %w", playlistID, err)
+	}
+	if err := p.checkTracksExist(trackIDs); err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("adding tracks to playlist %s: %w", playlistID, err)
+	}
+	p.appendTracks(pl, trackIDs)
+	return copyPlaylist(*pl), nil
+}
+
+// TruncatePlaylist removes all tracks from the playlist. The snapshot ID is only
+// used by the SpotifyAdaptor for caching, so it isn't checked.
+func (p *Provider) TruncatePlaylist(ctx context.Context, playlistID, snapshotID string) error {
+	if err := ctxhelper.Closed(ctx); err != nil {
+		return fmt.Errorf("truncating playlist %s: %w", playlistID, err)
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("TruncatePlaylist")
+
+	pl, err := p.modifiablePlaylist(playlistID)
+	if err != nil {
+		return fmt.Errorf("truncating playlist %s: %w", playlistID, err)
+	}
+	if len(pl.Tracks.Tracks) == 0 {
+		return nil
+	}
+	pl.Tracks.Tracks = nil
+	p.playlistChanged(pl)
+	return nil
+}
+
+func (p *Provider) modifiablePlaylist(playlistID string) (*spotify.FullPlaylist, error) {
+	pl, ok := p.playlists[spotify.ID(playlistID)]
+	if !ok {
+		return nil, notFound("playlist", playlistID)
+	}
+	if pl.Owner.ID != p.currentUserID {
+		return nil, forbidden(fmt.Sprintf("playlist %s is owned by %s", playlistID, pl.Owner.ID))
+	}
+	return pl, nil
+}
+
+func (p *Provider) checkTracksExist(trackIDs []string) error {
+	for _, id := range trackIDs {
+		if _, ok := p.tracks[spotify.ID(id)]; !ok {
+			return spotify.Error{Message: fmt.Sprintf("invalid track uri: spotify:track:%s", id), Status: http.StatusBadRequest}
+		}
+	}
+	return nil
+}
+
+func (p *Provider) appendTracks(pl *spotify.FullPlaylist, trackIDs []string) {
+	if len(trackIDs) == 0 {
+		return
+	}
+	addedAt := p.now().UTC().Format(spotify.TimestampLayout)
+	for _, id := range trackIDs {
+		pl.Tracks.Tracks = append(pl.Tracks.Tracks, spotify.PlaylistTrack{
+			AddedAt: addedAt,
+			AddedBy: p.users[p.currentUserID],
+			Track:   p.tracks[spotify.ID(id)],
+		})
+	}
+	p.playlistChanged(pl)
+}
+
+// playlistChanged gives the playlist a new snapshot ID and updates its totals.
+func (p *Provider) playlistChanged(pl *spotify.FullPlaylist) {
+	pl.SnapshotID = p.nextSnapshotID()
+	pl.Tracks.Total = len(pl.Tracks.Tracks)
+	pl.Tracks.Limit = len(pl.Tracks.Tracks)
+	pl.SimplePlaylist.Tracks.Total = uint(len(pl.Tracks.Tracks))
+}
+
+func (p *Provider) addPlaylist(userID, name string) *spotify.FullPlaylist {
+	id := p.nextID("playlist")
+	pl := &spotify.FullPlaylist{
+		SimplePlaylist: spotify.SimplePlaylist{
+			ID:         id,
+			Name:       name,
+			Owner:      p.users[userID],
+			URI:        spotify.URI("spotify:playlist:" + id),
+			SnapshotID: p.nextSnapshotID(),
+		},
+	}
+	p.playlists[id] = pl
+	p.playlistOrder = append(p.playlistOrder, id)
+	return pl
+}
+
+func copyPlaylist(pl spotify.FullPlaylist) spotify.FullPlaylist {
+	pl.Tracks.Tracks = append([]spotify.PlaylistTrack(nil), pl.Tracks.Tracks...)
+	return pl
+}
+
+// removeTracks removes every occurrence of the tracks from the playlist, like
+// Spotify's DELETE /playlists/{id}/tracks without positions.
+func (p *Provider) removeTracks(playlistID string, trackIDs []string) (spotify.FullPlaylist, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.called("removeTracks")
+
+	pl, err := p.modifiablePlaylist(playlistID)
+	if err != nil {
+		return spotify.FullPlaylist{}, fmt.Errorf("removing tracks from playlist %s: %w", playlistID, err)
+	}
+	remove := make(map[spotify.ID]bool, len(trackIDs))
+	for _, id := range trackIDs {
+		remove[spotify.ID(id)] = true
+	}
+	kept := make([]spotify.PlaylistTrack, 0, len(pl.Tracks.Tracks))
+	for _, t := range pl.Tracks.Tracks {
+		if !remove[t.Track.ID] {
+			kept = append(kept, t)
+		}
+	}
+	pl.Tracks.Tracks = kept
+	p.playlistChanged(pl)
+	return copyPlaylist(*pl), nil
+}
@@ -0,0 +1,532 @@
+package spotifytest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 50
+	// playlistTrackPageLimit is how many tracks playlists are returned with, and
+	// the default limit when listing their tracks.
+	playlistTrackPageLimit = 100
+	tokenTTL               = time.Hour
+)
+
+// Fault makes requests fail, to test how rate limiting and server errors are handled.
+type Fault struct {
+	// Path is matched as a prefix of the request path, e.g. "/v1/playlists/".
+	// An empty path matches every request.
+	Path string
+	// Status is the status code of the failed responses, e.g. 429 or 503.
+	Status int
+	// RetryAfter is sent in the Retry-After header, in whole seconds, when set.
+	RetryAfter time.Duration
+	// Probability is the chance of a matching request failing, where zero means always.
+	Probability float64
+	// Times is the number of requests which fail before the fault is cleared,
+	// where zero means never.
+	Times int
+}
+
+// Server is a mock of the Spotify Web API and accounts service serving the data
+// of a Provider, for running the zmb3/spotify client against. Authorizing always
+// succeeds as the Provider's current user, and every request made with a token
+// the server has handed out acts as that user.
+//
+// The Web API is served under /v1/ and the accounts service at /authorize and
+// /api/token, see Endpoint.
+type Server struct {
+	provider *Provider
+	router   chi.Router
+
+	mux    sync.Mutex
+	codes  map[string]bool
+	tokens map[string]bool
+	faults []*Fault
+}
+
+func NewServer(provider *Provider) *Server {
+	s := &Server{
+		provider: provider,
+		codes:    make(map[string]bool),
+		tokens:   make(map[string]bool),
+	}
+
+	r := chi.NewRouter()
+	r.Use(s.injectFaults)
+	r.Get("/authorize", s.authorize)
+	r.Post("/api/token", s.token)
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(s.authenticate)
+		r.Get("/me", s.getCurrentUser)
+		r.Get("/me/player/currently-playing", s.getCurrentlyPlaying)
+		r.Get("/users/{userID}/playlists", s.listPlaylists)
+		r.Post("/users/{userID}/playlists", s.createPlaylist)
+		r.Get("/playlists/{playlistID}", s.getPlaylist)
+		r.Get("/playlists/{playlistID}/tracks", s.listPlaylistTracks)
+		r.Post("/playlists/{playlistID}/tracks", s.addPlaylistTracks)
+		r.Delete("/playlists/{playlistID}/tracks", s.removePlaylistTracks)
+		r.Get("/albums", s.getAlbums)
+		r.Get("/albums/{albumID}", s.getAlbum)
+		r.Get("/artists/{artistID}/albums", s.listArtistAlbums)
+		r.Get("/tracks/{trackID}", s.getTrack)
+	})
+	s.router = r
+	return s
+}
+
+// Start serves the mock on a local port until the returned server is closed.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// Endpoint returns the endpoint to configure the AuthAdaptor with for the server at baseURL.
+func Endpoint(baseURL string) (recommendations.SpotifyEndpoint, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return recommendations.SpotifyEndpoint{}, fmt.Errorf("parsing base url: %w", err)
+	}
+	apiURL, accountsURL := *u, *u
+	apiURL.Path = path.Join("/", u.Path, "v1") + "/"
+	accountsURL.Path = strings.TrimSuffix(path.Join("/", u.Path), "/") + "/"
+	return recommendations.SpotifyEndpoint{APIURL: apiURL, AccountsURL: accountsURL}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) InjectFault(fault Fault) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+func (s *Server) ClearFaults() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.faults = nil
+}
+
+// Token hands out a token, as if the current user had signed in.
+func (s *Server) Token() *oauth2.Token {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.newToken()
+}
+
+func (s *Server) newToken() *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  randomString(),
+		RefreshToken: randomString(),
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(tokenTTL),
+	}
+	s.tokens[token.AccessToken] = true
+	return token
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("spotifytest: reading random bytes: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// matchFault returns the first fault matching the request, using up one of its times.
+func (s *Server) matchFault(r *http.Request) (Fault, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, f := range s.faults {
+		if !strings.HasPrefix(r.URL.Path, f.Path) {
+			continue
+		}
+		if f.Probability > 0 && mathrand.Float64() >= f.Probability {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return *f, true
+	}
+	return Fault{}, false
+}
+
+func (s *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault, ok := s.matchFault(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter.Seconds())))
+		}
+		writeError(w, fault.Status, http.StatusText(fault.Status))
+	})
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mux.Lock()
+		valid := ok && s.tokens[accessToken]
+		s.mux.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize approves every authorization request, redirecting straight back.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURL.String() == "" {
+		writeError(w, http.StatusBadRequest, "Invalid redirect URI")
+		return
+	}
+
+	code := randomString()
+	s.mux.Lock()
+	s.codes[code] = true
+	s.mux.Unlock()
+
+	values := redirectURL.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURL.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, "invalid_request")
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		if !s.codes[code] {
+			writeTokenError(w, "invalid_grant")
+			return
+		}
+		delete(s.codes, code)
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") == "" {
+			writeTokenError(w, "invalid_grant")
+			return
+		}
+	default:
+		writeTokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	token := s.newToken()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  token.AccessToken,
+		"token_type":    token.TokenType,
+		"expires_in":    int(tokenTTL.Seconds()),
+		"refresh_token": token.RefreshToken,
+	})
+}
+
+func (s *Server) getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	usr, err := s.provider.CurrentUser(r.Context())
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, spotify.PrivateUser{User: usr, Product: "premium"})
+}
+
+func (s *Server) getCurrentlyPlaying(w http.ResponseWriter, r *http.Request) {
+	track, playing, err := s.provider.CurrentTrack(r.Context())
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if !playing {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, spotify.CurrentlyPlaying{
+		Timestamp: time.Now().UnixMilli(),
+		Playing:   true,
+		Item:      &track,
+	})
+}
+
+func (s *Server) listPlaylists(w http.ResponseWriter, r *http.Request) {
+	playlists, err := s.provider.ListPlaylists(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writePage(w, r, playlists, defaultPageLimit)
+}
+
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	playlist, err := s.provider.CreatePlaylist(r.Context(), chi.URLParam(r, "userID"), body.Name, nil)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, playlist)
+}
+
+// getPlaylist returns the playlist with its first page of tracks, like Spotify does.
+func (s *Server) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlist, err := s.provider.GetPlaylist(r.Context(), chi.URLParam(r, "playlistID"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	tracks := playlist.Tracks.Tracks
+	playlist.Tracks.Tracks = tracks[:min(len(tracks), playlistTrackPageLimit)]
+	playlist.Tracks.Limit = playlistTrackPageLimit
+	playlist.Tracks.Total = len(tracks)
+	writeJSON(w, http.StatusOK, playlist)
+}
+
+func (s *Server) listPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	playlist, err := s.provider.GetPlaylist(r.Context(), chi.URLParam(r, "playlistID"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writePage(w, r, playlist.Tracks.Tracks, playlistTrackPageLimit)
+}
+
+func (s *Server) addPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URIs []string `json:"uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	trackIDs, err := trackIDsOf(body.URIs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	playlist, err := s.provider.SetPlaylistTracks(r.Context(), chi.URLParam(r, "playlistID"), trackIDs)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"snapshot_id": playlist.SnapshotID})
+}
+
+func (s *Server) removePlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Tracks []struct {
+			URI string `json:"uri"`
+		} `json:"tracks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	uris := make([]string, 0, len(body.Tracks))
+	for _, t := range body.Tracks {
+		uris = append(uris, t.URI)
+	}
+	trackIDs, err := trackIDsOf(uris)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	playlist, err := s.provider.removeTracks(chi.URLParam(r, "playlistID"), trackIDs)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"snapshot_id": playlist.SnapshotID})
+}
+
+// getAlbums returns null for albums which don't exist, like Spotify does.
+func (s *Server) getAlbums(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > 20 {
+		writeError(w, http.StatusBadRequest, "Too many ids requested")
+		return
+	}
+	albums := make([]*spotify.FullAlbum, 0, len(ids))
+	for _, id := range ids {
+		album, err := s.provider.GetAlbum(r.Context(), id)
+		if err != nil {
+			albums = append(albums, nil)
+			continue
+		}
+		albums = append(albums, &album)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"albums": albums})
+}
+
+func (s *Server) getAlbum(w http.ResponseWriter, r *http.Request) {
+	album, err := s.provider.GetAlbum(r.Context(), chi.URLParam(r, "albumID"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, album)
+}
+
+func (s *Server) listArtistAlbums(w http.ResponseWriter, r *http.Request) {
+	albums, err := s.provider.ListArtistAlbums(r.Context(), chi.URLParam(r, "artistID"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if groups := r.URL.Query().Get("include_groups"); groups != "" {
+		included := strings.Split(groups, ",")
+		filtered := make([]spotify.SimpleAlbum, 0, len(albums))
+		for _, a := range albums {
+			for _, g := range included {
+				if a.AlbumGroup == g {
+					filtered = append(filtered, a)
+					break
+				}
+			}
+		}
+		albums = filtered
+	}
+	writePage(w, r, albums, defaultPageLimit)
+}
+
+func (s *Server) getTrack(w http.ResponseWriter, r *http.Request) {
+	track, err := s.provider.GetTrack(r.Context(), chi.URLParam(r, "trackID"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, track)
+}
+
+func trackIDsOf(uris []string) ([]string, error) {
+	ids := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		id, ok := strings.CutPrefix(uri, "spotify:track:")
+		if !ok {
+			return nil, fmt.Errorf("Invalid track uri: %s", uri)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// writePage writes a page of the items according to the limit and offset query
+// parameters, with next and previous links like Spotify's paging objects.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T, defaultLimit int) {
+	query := r.URL.Query()
+	limit, offset := defaultLimit, 0
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > max(maxPageLimit, defaultLimit) {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = l
+	}
+	if v := query.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+		offset = o
+	}
+
+	from, to := min(offset, len(items)), min(offset+limit, len(items))
+	pageURL := func(offset int) string {
+		u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+		values := r.URL.Query()
+		values.Set("limit", strconv.Itoa(limit))
+		values.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = values.Encode()
+		return u.String()
+	}
+	page := map[string]any{
+		"href":     pageURL(offset),
+		"items":    items[from:to],
+		"limit":    limit,
+		"offset":   offset,
+		"total":    len(items),
+		"next":     nil,
+		"previous": nil,
+	}
+	if to < len(items) {
+		page["next"] = pageURL(to)
+	}
+	if offset > 0 {
+		page["previous"] = pageURL(max(offset-limit, 0))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the Web API's format, which the spotify client decodes into a spotify.Error.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]spotify.Error{"error": {Status: status, Message: message}})
+}
+
+func writeTokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeProviderError(w http.ResponseWriter, err error) {
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) {
+		writeError(w, spotifyErr.Status, spotifyErr.Message)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
@@ -5,23 +5,208 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/zmb3/spotify"
 )
 
 type TrackIndex interface {
-	Has(ctx context.Context, userID string, track spotify.SimpleTrack) (bool, error)
-	Lookup(ctx context.Context, userID string, track spotify.SimpleTrack) ([]spotify.SimplePlaylist, error)
+	Has(ctx context.Context, userID string, track spotify.FullTrack) (bool, error)
+	// Lookup returns the playlists the track is on, matching it by ISRC first and by key second.
+	Lookup(ctx context.Context, userID string, track spotify.FullTrack) ([]spotify.SimplePlaylist, error)
 	Diff(ctx context.Context, userID string, playlists []spotify.SimplePlaylist) (added, changed, removed []spotify.SimplePlaylist, err error)
-	Sync(ctx context.Context, userID string, added, changed, removed []spotify.FullPlaylist) error
-	CountTracksByArtist(ctx context.Context, userID string, artistName string) (int, error)
+	// Sync writes the added and changed playlists' tracks to the index and drops the
+	// removed playlists. Removed playlists don't need to be populated.
+	Sync(ctx context.Context, userID string, added, changed []spotify.FullPlaylist, removed []spotify.SimplePlaylist) (SyncStats, error)
+	// CountTracksByArtists counts the indexed tracks of each artist, artists without
+	// any tracks are left out.
+	CountTracksByArtists(ctx context.Context, userID string, artistIDs []spotify.ID) (map[spotify.ID]int, error)
 	Summarize(ctx context.Context, userID string) (IndexSummary, error)
+	Search(ctx context.Context, userID string, search TrackSearch) (TrackSearchResult, error)
+	// Duplicates lists the tracks which are on more than one playlist. If nearKey is set,
+	// tracks whose keys differ but which get the same near key are listed as well.
+	Duplicates(ctx context.Context, userID string, nearKey func(spotify.SimpleTrack) string) ([]DuplicateTrack, error)
+	// History lists the user's track events, newest first.
+	History(ctx context.Context, userID string, query HistoryQuery) ([]TrackEvent, error)
+	// TrackHistory lists the events of the track, oldest first. Like Lookup it matches
+	// the track by ISRC as well as by key.
+	TrackHistory(ctx context.Context, userID string, track spotify.FullTrack) ([]TrackEvent, error)
+}
+
+const (
+	TrackEventAdded   = "added"
+	TrackEventRemoved = "removed"
+
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 500
+)
+
+// TrackEvent records a track being added to or removed from a library playlist.
+// Added events use the time Spotify says the track was added, removed events the
+// time the removal was noticed.
+type TrackEvent struct {
+	Type         string    `json:"type"`
+	PlaylistID   string    `json:"playlist_id"`
+	PlaylistName string    `json:"playlist_name"`
+	TrackKey     string    `json:"track_key"`
+	TrackName    string    `json:"track_name"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+// Description describes the event from the track's point of view, e.g.
+// "added to Metal 12 on 2024-03-02".
+func (e TrackEvent) Description() string {
+	preposition := "to"
+	if e.Type == TrackEventRemoved {
+		preposition = "from"
+	}
+	return fmt.Sprintf("%s %s %s on %s", e.Type, preposition, e.PlaylistName, e.OccurredAt.Format(time.DateOnly))
+}
+
+// HistoryQuery filters the history. Playlist matches playlist names or IDs like
+// in TrackSearch.
+type HistoryQuery struct {
+	Playlist string
+	Limit    int
+	Offset   int
+}
+
+func (q HistoryQuery) Validate() error {
+	fieldErrs := make(map[string]string)
+	if q.Limit < 0 || q.Limit > maxHistoryLimit {
+		fieldErrs["limit"] = fmt.Sprintf("must be between 0 and %d", maxHistoryLimit)
+	}
+	if q.Offset < 0 {
+		fieldErrs["offset"] = "must not be negative"
+	}
+	if len(fieldErrs) > 0 {
+		return ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+const (
+	TrackSortName       = "name"
+	TrackSortArtist     = "artist"
+	TrackSortPopularity = "popularity"
+	TrackSortAdded      = "added"
+
+	defaultTrackSearchLimit = 50
+	maxTrackSearchLimit     = 200
+)
+
+var trackSortFields = []string{TrackSortName, TrackSortArtist, TrackSortPopularity, TrackSortAdded}
+
+// TrackSearch filters the indexed tracks. Query matches track or artist names, Artist
+// only artist names and Playlist playlist names or IDs. All of them are case insensitive
+// substring matches and empty ones match everything.
+type TrackSearch struct {
+	Query      string
+	Artist     string
+	Playlist   string
+	Sort       string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+// ParseTrackSort parses sort fields such as "name" or "-popularity", where the leading
+// dash sorts in descending order.
+func ParseTrackSort(v string) (field string, descending bool, err error) {
+	if v == "" {
+		return TrackSortName, false, nil
+	}
+	field, descending = strings.CutPrefix(v, "-")
+	if !stringsContain(trackSortFields, field) {
+		return "", false, ValidationError{Fields: map[string]string{
+			"sort": fmt.Sprintf("must be one of %s, optionally prefixed with -", strings.Join(trackSortFields, ", ")),
+		}}
+	}
+	return field, descending, nil
+}
+
+func (s TrackSearch) Validate() error {
+	fieldErrs := make(map[string]string)
+	if s.Limit < 0 || s.Limit > maxTrackSearchLimit {
+		fieldErrs["limit"] = fmt.Sprintf("must be between 0 and %d", maxTrackSearchLimit)
+	}
+	if s.Offset < 0 {
+		fieldErrs["offset"] = "must not be negative"
+	}
+	if s.Sort != "" && !stringsContain(trackSortFields, s.Sort) {
+		fieldErrs["sort"] = fmt.Sprintf("must be one of %s", strings.Join(trackSortFields, ", "))
+	}
+	if len(fieldErrs) > 0 {
+		return ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+type TrackSearchResult struct {
+	Tracks []IndexedTrack `json:"tracks"`
+	// Total is the number of tracks matching the search, regardless of limit and offset.
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// IndexedTrack is a track in the index along with all library playlists it's on.
+type IndexedTrack struct {
+	Key       string                   `json:"key"`
+	Track     spotify.FullTrack        `json:"track"`
+	Playlists []spotify.SimplePlaylist `json:"playlists"`
+}
+
+// SyncStats counts what a TrackIndex.Sync changed. Playlist tracks are the tracks'
+// memberships of playlists, while orphaned tracks are the tracks dropped from the
+// index altogether as they're no longer on any playlist.
+type SyncStats struct {
+	PlaylistsAdded        int `json:"playlists_added"`
+	PlaylistsChanged      int `json:"playlists_changed"`
+	PlaylistsRemoved      int `json:"playlists_removed"`
+	PlaylistTracksAdded   int `json:"playlist_tracks_added"`
+	PlaylistTracksRemoved int `json:"playlist_tracks_removed"`
+	TracksOrphaned        int `json:"tracks_orphaned"`
+}
+
+// DuplicateTrack is a track found on several playlists. Near duplicates are
+// different tracks according to the index, e.g. a track and its remaster, in which
+// case Keys and Tracks hold each variant.
+type DuplicateTrack struct {
+	Keys      []string                 `json:"keys"`
+	Near      bool                     `json:"near"`
+	Tracks    []spotify.FullTrack      `json:"tracks"`
+	Playlists []spotify.SimplePlaylist `json:"playlists"`
 }
 
 type IndexSummary struct {
 	PlaylistCount    int
 	UniqueTrackCount int
-	Playlists        []spotify.SimplePlaylist
+	// TrackCount counts tracks once for every playlist they're on.
+	TrackCount        int
+	Playlists         []spotify.SimplePlaylist
+	TopArtists        []ArtistTrackCount
+	TracksPerPlaylist []PlaylistTrackCount
+	// ReleaseYears is a histogram of the tracks' album release years, sorted by year.
+	ReleaseYears []ReleaseYearCount
+	AlbumTypes   map[string]int
+}
+
+type ArtistTrackCount struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	TrackCount int    `json:"track_count"`
+}
+
+type PlaylistTrackCount struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	TrackCount int    `json:"track_count"`
+}
+
+type ReleaseYearCount struct {
+	Year       int `json:"year"`
+	TrackCount int `json:"track_count"`
 }
 
 func TrackKey(tt spotify.SimpleTrack) string {
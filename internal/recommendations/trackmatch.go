@@ -0,0 +1,120 @@
+package recommendations
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/zmb3/spotify"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MatchStrictness controls how different two tracks' names and artists may be
+// for them to still be considered the same track.
+type MatchStrictness string
+
+const (
+	// MatchExact only matches identical names and artists, see TrackKey.
+	MatchExact MatchStrictness = "exact"
+	// MatchNormalized ignores casing, diacritics, punctuation variants and whitespace.
+	MatchNormalized MatchStrictness = "normalized"
+	// MatchLoose also ignores remaster, edition and live suffixes as well as featured artists
+	// in the track name.
+	MatchLoose MatchStrictness = "loose"
+)
+
+func ParseMatchStrictness(v string) (MatchStrictness, error) {
+	switch s := MatchStrictness(strings.ToLower(v)); s {
+	case MatchExact, MatchNormalized, MatchLoose:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown match strictness %q, must be one of %s, %s or %s", v, MatchExact, MatchNormalized, MatchLoose)
+	}
+}
+
+// TrackMatcher decides which tracks are the same by giving them the same key.
+// The key is what the TrackIndex stores tracks by.
+type TrackMatcher struct {
+	strictness MatchStrictness
+}
+
+func NewTrackMatcher(strictness MatchStrictness) *TrackMatcher {
+	return &TrackMatcher{strictness: strictness}
+}
+
+func (m *TrackMatcher) Strictness() MatchStrictness {
+	return m.strictness
+}
+
+// Key returns the key of the track, tracks with the same key are considered the same.
+func (m *TrackMatcher) Key(track spotify.SimpleTrack) string {
+	if m.strictness == MatchExact {
+		return TrackKey(track)
+	}
+
+	name := normalizeString(track.Name)
+	if m.strictness == MatchLoose {
+		name = stripTrackNameSuffixes(name)
+	}
+
+	artistNames := make([]string, 0, len(track.Artists))
+	seen := make(map[string]bool)
+	for _, a := range track.Artists {
+		n := normalizeString(a.Name)
+		if !seen[n] {
+			seen[n] = true
+			artistNames = append(artistNames, n)
+		}
+	}
+	sort.Strings(artistNames)
+
+	return fmt.Sprintf("%s - %s", name, strings.Join(artistNames, ", "))
+}
+
+var punctuationReplacer = strings.NewReplacer(
+	"‘", "'", "’", "'", "`", "'",
+	"“", `"`, "”", `"`,
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-",
+	"&", "and",
+)
+
+// normalizeString case folds s, strips diacritics and collapses whitespace.
+func normalizeString(s string) string {
+	decomposed := norm.NFKD.String(s)
+	b := strings.Builder{}
+	b.Grow(len(decomposed))
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	s = punctuationReplacer.Replace(b.String())
+	return strings.Join(strings.Fields(s), " ")
+}
+
+var (
+	// versionKeywords are words which, when found in a parenthesised or dashed suffix,
+	// mean the suffix only describes the release rather than the song.
+	versionKeywords = `remaster|remastered|edition|deluxe|anniversary|live|mono|stereo|bonus track|single version|album version|radio edit|explicit|clean`
+	bracketSuffix   = regexp.MustCompile(`\s*[(\[][^()\[\]]*\b(?:` + versionKeywords + `)\b[^()\[\]]*[)\]]\s*$`)
+	dashSuffix      = regexp.MustCompile(`\s+-\s+[^-]*\b(?:` + versionKeywords + `)\b[^-]*$`)
+	featuring       = regexp.MustCompile(`\s*(?:[(\[]\s*(?:feat\.?|ft\.?|featuring|with)\s[^()\[\]]*[)\]]|\s-\s(?:feat\.?|ft\.?|featuring)\s.*|\s(?:feat\.|ft\.|featuring)\s.*)$`)
+)
+
+// stripTrackNameSuffixes strips featured artists and release descriptions such as
+// "(Remastered 2011)" or "- Live" from the end of a normalized track name.
+// Featured artists are also listed as the track's artists, so nothing is lost.
+func stripTrackNameSuffixes(name string) string {
+	for {
+		stripped := featuring.ReplaceAllString(name, "")
+		stripped = bracketSuffix.ReplaceAllString(stripped, "")
+		stripped = dashSuffix.ReplaceAllString(stripped, "")
+		if stripped == name || stripped == "" {
+			return name
+		}
+		name = stripped
+	}
+}
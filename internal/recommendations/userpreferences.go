@@ -0,0 +1,217 @@
+package recommendations
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const week = 7 * 24 * time.Hour
+
+// DefaultUserPreferences returns the preferences used for users who haven't
+// stored any of their own.
+func DefaultUserPreferences() UserPreferences {
+	return UserPreferences{
+		LibraryPattern:         regexp.MustCompile(`^Metal \d+`),
+		DiscoveryPlaylistNames: []string{"Release Radar", "Discover Weekly"},
+		WeightedWords: map[string]int{
+			"instrumental": -50,
+			"acoustic":     -30,
+			"re-imagined":  -30,
+			"remix":        -30,
+		},
+		MinimumAlbumSize:                 4,
+		RecommendationPlaylistNamePrefix: "recommendli",
+		RecommendationCooldown:           4 * week,
+		Scorers:                          defaultScorerPreferences(),
+	}
+}
+
+type userPreferencesJSON struct {
+	LibraryPattern                   *string                      `json:"library_pattern,omitempty"`
+	DiscoveryPlaylistNames           *[]string                    `json:"discovery_playlist_names,omitempty"`
+	WeightedWords                    *map[string]int              `json:"weighted_words,omitempty"`
+	MinimumAlbumSize                 *int                         `json:"minimum_album_size,omitempty"`
+	MinimumPopularity                *int                         `json:"minimum_popularity,omitempty"`
+	MaximumTrackDurationSeconds      *int                         `json:"maximum_track_duration_seconds,omitempty"`
+	RecommendationPlaylistNamePrefix *string                      `json:"recommendation_playlist_name_prefix,omitempty"`
+	RecommendationCooldownWeeks      *int                         `json:"recommendation_cooldown_weeks,omitempty"`
+	Scorers                          *map[string]ScorerPreference `json:"scorers,omitempty"`
+}
+
+func (u UserPreferences) MarshalJSON() ([]byte, error) {
+	var libraryPattern string
+	if u.LibraryPattern != nil {
+		libraryPattern = u.LibraryPattern.String()
+	}
+	maximumTrackDurationSeconds := int(u.MaximumTrackDuration.Seconds())
+	recommendationCooldownWeeks := u.RecommendationCooldownWeeks()
+	return json.Marshal(userPreferencesJSON{
+		LibraryPattern:                   &libraryPattern,
+		DiscoveryPlaylistNames:           &u.DiscoveryPlaylistNames,
+		WeightedWords:                    &u.WeightedWords,
+		MinimumAlbumSize:                 &u.MinimumAlbumSize,
+		MinimumPopularity:                &u.MinimumPopularity,
+		MaximumTrackDurationSeconds:      &maximumTrackDurationSeconds,
+		RecommendationPlaylistNamePrefix: &u.RecommendationPlaylistNamePrefix,
+		RecommendationCooldownWeeks:      &recommendationCooldownWeeks,
+		Scorers:                          &u.Scorers,
+	})
+}
+
+// UnmarshalJSON only overwrites the fields present in the data, which makes it
+// possible to unmarshal on top of the defaults (or any other preferences).
+func (u *UserPreferences) UnmarshalJSON(data []byte) error {
+	var raw userPreferencesJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("unmarshalling user preferences: %w", err)
+	}
+
+	prefs, err := raw.applyTo(*u)
+	if err != nil {
+		return fmt.Errorf("unmarshalling user preferences: %w", err)
+	}
+	*u = prefs
+
+	return nil
+}
+
+// applyTo validates the fields present in raw and sets them on prefs.
+// All invalid fields are reported together in a ValidationError.
+func (raw userPreferencesJSON) applyTo(prefs UserPreferences) (UserPreferences, error) {
+	fieldErrs := make(map[string]string)
+
+	if raw.LibraryPattern != nil {
+		re, err := regexp.Compile(*raw.LibraryPattern)
+		if err != nil {
+			fieldErrs["library_pattern"] = fmt.Sprintf("must be a valid regular expression: %s", err)
+		} else {
+			prefs.LibraryPattern = re
+		}
+	}
+	if raw.DiscoveryPlaylistNames != nil {
+		prefs.DiscoveryPlaylistNames = *raw.DiscoveryPlaylistNames
+	}
+	if raw.WeightedWords != nil {
+		for word := range *raw.WeightedWords {
+			if strings.TrimSpace(word) == "" {
+				fieldErrs["weighted_words"] = "words must not be empty"
+			}
+		}
+		prefs.WeightedWords = *raw.WeightedWords
+	}
+	if raw.MinimumAlbumSize != nil {
+		if *raw.MinimumAlbumSize < 0 {
+			fieldErrs["minimum_album_size"] = "must not be negative"
+		}
+		prefs.MinimumAlbumSize = *raw.MinimumAlbumSize
+	}
+	if raw.MinimumPopularity != nil {
+		if *raw.MinimumPopularity < 0 || *raw.MinimumPopularity > 100 {
+			fieldErrs["minimum_popularity"] = "must be between 0 and 100"
+		}
+		prefs.MinimumPopularity = *raw.MinimumPopularity
+	}
+	if raw.MaximumTrackDurationSeconds != nil {
+		if *raw.MaximumTrackDurationSeconds < 0 {
+			fieldErrs["maximum_track_duration_seconds"] = "must not be negative"
+		}
+		prefs.MaximumTrackDuration = time.Duration(*raw.MaximumTrackDurationSeconds) * time.Second
+	}
+	if raw.RecommendationPlaylistNamePrefix != nil {
+		prefs.RecommendationPlaylistNamePrefix = *raw.RecommendationPlaylistNamePrefix
+	}
+	if raw.RecommendationCooldownWeeks != nil {
+		if *raw.RecommendationCooldownWeeks < 0 {
+			fieldErrs["recommendation_cooldown_weeks"] = "must not be negative"
+		}
+		prefs.RecommendationCooldown = time.Duration(*raw.RecommendationCooldownWeeks) * week
+	}
+	if raw.Scorers != nil {
+		// Scorers are set one by one, the ones not present keep their preferences.
+		scorers := make(map[string]ScorerPreference, len(prefs.Scorers)+len(*raw.Scorers))
+		for name, pref := range prefs.Scorers {
+			scorers[name] = pref
+		}
+		for name, pref := range *raw.Scorers {
+			if !stringsContain(scorerNames, name) {
+				fieldErrs["scorers"] = fmt.Sprintf("unknown scorer %q, must be one of %s", name, strings.Join(scorerNames, ", "))
+			}
+			if math.IsNaN(pref.Weight) || math.Abs(pref.Weight) > maxScorerWeight {
+				fieldErrs["scorers"] = fmt.Sprintf("weight of scorer %q must be between %d and %d", name, -maxScorerWeight, maxScorerWeight)
+			}
+			scorers[name] = pref
+		}
+		prefs.Scorers = scorers
+	}
+
+	if len(fieldErrs) > 0 {
+		return UserPreferences{}, ValidationError{Fields: fieldErrs}
+	}
+	return prefs, nil
+}
+
+// missingFields returns a ValidationError for every required field not present in
+// raw, used when the whole set of preferences is replaced. The recommendation
+// cooldown, and any scorers not present, keep their defaults.
+func (raw userPreferencesJSON) missingFields() error {
+	fieldErrs := make(map[string]string)
+	if raw.LibraryPattern == nil {
+		fieldErrs["library_pattern"] = "is required"
+	}
+	if raw.DiscoveryPlaylistNames == nil {
+		fieldErrs["discovery_playlist_names"] = "is required"
+	}
+	if raw.WeightedWords == nil {
+		fieldErrs["weighted_words"] = "is required"
+	}
+	if raw.MinimumAlbumSize == nil {
+		fieldErrs["minimum_album_size"] = "is required"
+	}
+	if raw.MinimumPopularity == nil {
+		fieldErrs["minimum_popularity"] = "is required"
+	}
+	if raw.MaximumTrackDurationSeconds == nil {
+		fieldErrs["maximum_track_duration_seconds"] = "is required"
+	}
+	if raw.RecommendationPlaylistNamePrefix == nil {
+		fieldErrs["recommendation_playlist_name_prefix"] = "is required"
+	}
+	if raw.Scorers == nil {
+		fieldErrs["scorers"] = "is required"
+	}
+	if len(fieldErrs) > 0 {
+		return ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// preview only keeps the fields that affect how playlists are classified,
+// everything else is irrelevant for PreviewPreferences.
+func (raw userPreferencesJSON) preview() userPreferencesJSON {
+	return userPreferencesJSON{
+		LibraryPattern:         raw.LibraryPattern,
+		DiscoveryPlaylistNames: raw.DiscoveryPlaylistNames,
+	}
+}
+
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (err ValidationError) Error() string {
+	fields := make([]string, 0, len(err.Fields))
+	for field := range err.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fmt.Sprintf("invalid fields: %s", strings.Join(fields, ", "))
+}
+
+func (err ValidationError) FieldErrors() map[string]string {
+	return err.Fields
+}
@@ -0,0 +1,36 @@
+package recommendations
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestUserPreferencesScorerWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weight  float64
+		wantErr bool
+	}{
+		{name: "zero", weight: 0},
+		{name: "negative", weight: -2.5},
+		{name: "largest", weight: maxScorerWeight},
+		{name: "too large", weight: 1e300, wantErr: true},
+		{name: "too small", weight: -maxScorerWeight - 1, wantErr: true},
+		{name: "infinite", weight: math.Inf(1), wantErr: true},
+		{name: "not a number", weight: math.NaN(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := userPreferencesJSON{Scorers: &map[string]ScorerPreference{
+				ScorerRecency: {Enabled: true, Weight: tt.weight},
+			}}
+			_, err := raw.applyTo(DefaultUserPreferences())
+			if tt.wantErr && !errors.As(err, &ValidationError{}) {
+				t.Errorf("applyTo() error = %v, want a ValidationError", err)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("applyTo() error = %v", err)
+			}
+		})
+	}
+}
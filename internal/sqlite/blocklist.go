@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+var _ recommendations.BlocklistStore = (*BlocklistStore)(nil)
+
+type BlocklistStore struct {
+	db *DB
+}
+
+func NewBlocklistStore(db *DB) *BlocklistStore {
+	return &BlocklistStore{db: db}
+}
+
+type blocklistRow struct {
+	Type       string `db:"type"`
+	ID         string `db:"id"`
+	Name       string `db:"name"`
+	InsertedAt string `db:"inserted_at"`
+}
+
+func (s *BlocklistStore) Add(ctx context.Context, userID string, entry recommendations.BlocklistEntry) error {
+	var trackJSON []byte
+	if entry.Track != nil {
+		var err error
+		if trackJSON, err = json.Marshal(entry.Track); err != nil {
+			return fmt.Errorf("marshalling track: %w", err)
+		}
+	}
+
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	if _, err := db.NamedExecContext(ctx, `
+		INSERT OR REPLACE INTO user_blocklist (user_id, type, id, name, track, inserted_at)
+		VALUES (:user_id, :type, :id, :name, :track, :inserted_at)
+	`, map[string]any{
+		"user_id":     userID,
+		"type":        entry.Type,
+		"id":          entry.ID,
+		"name":        entry.Name,
+		"track":       trackJSON,
+		"inserted_at": formatTime(entry.CreatedAt),
+	}); err != nil {
+		return fmt.Errorf("inserting blocklist entry for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *BlocklistStore) Remove(ctx context.Context, userID, entryType, id string) (bool, error) {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM user_blocklist
+		WHERE user_id = ? AND type = ? AND id = ?
+	`, userID, entryType, id)
+	if err != nil {
+		return false, fmt.Errorf("deleting blocklist entry for user %s: %w", userID, err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("counting deleted blocklist entries: %w", err)
+	}
+	return removed > 0, nil
+}
+
+func (s *BlocklistStore) List(ctx context.Context, userID string) ([]recommendations.BlocklistEntry, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var rows []blocklistRow
+	if err := db.SelectContext(ctx, &rows, `
+		SELECT type, id, name, inserted_at
+		FROM user_blocklist
+		WHERE user_id = ?
+		ORDER BY inserted_at DESC, type, id
+	`, userID); err != nil {
+		return nil, fmt.Errorf("querying blocklist for user %s: %w", userID, err)
+	}
+
+	entries := make([]recommendations.BlocklistEntry, 0, len(rows))
+	for _, row := range rows {
+		createdAt, err := parseTime(row.InsertedAt)
+		if err != nil {
+			return nil, fmt.Errorf("parsing inserted_at: %w", err)
+		}
+		entries = append(entries, recommendations.BlocklistEntry{
+			Type:      row.Type,
+			ID:        row.ID,
+			Name:      row.Name,
+			CreatedAt: createdAt,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kristofferostlund/recommendli/pkg/migrations"
+)
+
+var testDBs atomic.Int32
+
+// newTestDB returns a migrated in-memory database which lives until the test ends.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := Open(fmt.Sprintf("file:test-%d?mode=memory&cache=shared", testDBs.Add(1)))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dir, err := filepath.Abs("../../migrations")
+	if err != nil {
+		t.Fatalf("resolving migrations: %v", err)
+	}
+	if err := migrations.UpSQLite("file://"+dir, db.DB); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return Wrap(db)
+}
@@ -0,0 +1,212 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+	"github.com/zmb3/spotify"
+)
+
+var _ recommendations.DiscoveryJobStore = (*DiscoveryJobStore)(nil)
+
+type DiscoveryJobStore struct {
+	db         *DB
+	instanceID string
+}
+
+// NewDiscoveryJobStore returns a DiscoveryJobStore for the jobs run by the
+// instance identified by instanceID.
+func NewDiscoveryJobStore(db *DB, instanceID string) *DiscoveryJobStore {
+	return &DiscoveryJobStore{db: db, instanceID: instanceID}
+}
+
+type discoveryJobRow struct {
+	ID         string         `db:"id"`
+	UserID     string         `db:"user_id"`
+	DryRun     bool           `db:"dry_run"`
+	State      string         `db:"state"`
+	Progress   []byte         `db:"progress"`
+	Error      sql.NullString `db:"error"`
+	Playlist   []byte         `db:"playlist"`
+	InsertedAt string         `db:"inserted_at"`
+	UpdatedAt  string         `db:"updated_at"`
+}
+
+func (s *DiscoveryJobStore) Create(ctx context.Context, job recommendations.DiscoveryJob) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	values, err := discoveryJobValues(job)
+	if err != nil {
+		return err
+	}
+	values["instance_id"] = s.instanceID
+
+	if _, err := db.NamedExecContext(ctx, `
+		INSERT INTO discovery_jobs (id, user_id, dry_run, state, progress, error, playlist, instance_id, heartbeat_at)
+		VALUES (:id, :user_id, :dry_run, :state, :progress, :error, :playlist, :instance_id, datetime('now'))
+	`, values); err != nil {
+		return fmt.Errorf("inserting discovery job %s: %w", job.ID, err)
+	}
+
+	return nil
+}
+
+func (s *DiscoveryJobStore) Update(ctx context.Context, job recommendations.DiscoveryJob) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	values, err := discoveryJobValues(job)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.NamedExecContext(ctx, `
+		UPDATE discovery_jobs
+		SET state = :state,
+			progress = :progress,
+			error = :error,
+			playlist = :playlist,
+			updated_at = datetime('now'),
+			heartbeat_at = datetime('now')
+		WHERE id = :id AND user_id = :user_id
+	`, values); err != nil {
+		return fmt.Errorf("updating discovery job %s: %w", job.ID, err)
+	}
+
+	return nil
+}
+
+func (s *DiscoveryJobStore) Get(ctx context.Context, userID, jobID string) (recommendations.DiscoveryJob, bool, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var row discoveryJobRow
+	if err := db.GetContext(ctx, &row, `
+		SELECT id, user_id, dry_run, state, progress, error, playlist, inserted_at, updated_at
+		FROM discovery_jobs
+		WHERE id = ? AND user_id = ?
+	`, jobID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return recommendations.DiscoveryJob{}, false, nil
+		}
+		return recommendations.DiscoveryJob{}, false, fmt.Errorf("querying discovery job %s: %w", jobID, err)
+	}
+
+	job, err := row.toDiscoveryJob()
+	if err != nil {
+		return recommendations.DiscoveryJob{}, false, fmt.Errorf("reading discovery job %s: %w", jobID, err)
+	}
+
+	return job, true, nil
+}
+
+func (s *DiscoveryJobStore) Heartbeat(ctx context.Context, userID, jobID string) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE discovery_jobs
+		SET heartbeat_at = datetime('now')
+		WHERE id = ? AND user_id = ?
+	`, jobID, userID); err != nil {
+		return fmt.Errorf("updating heartbeat of discovery job %s: %w", jobID, err)
+	}
+
+	return nil
+}
+
+func (s *DiscoveryJobStore) FailUnfinished(ctx context.Context, staleAfter time.Duration, reason string) (int, error) {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	result, err := db.NamedExecContext(ctx, `
+		UPDATE discovery_jobs
+		SET state = :failed,
+			error = :reason,
+			updated_at = datetime('now')
+		WHERE state NOT IN (:done, :failed)
+			AND (
+				instance_id = :instance_id
+				OR heartbeat_at IS NULL
+				OR heartbeat_at < :stale_before
+			)
+	`, map[string]any{
+		"failed":       recommendations.JobStateFailed,
+		"done":         recommendations.JobStateDone,
+		"reason":       reason,
+		"instance_id":  s.instanceID,
+		"stale_before": formatTime(time.Now().Add(-staleAfter)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failing unfinished discovery jobs: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+func discoveryJobValues(job recommendations.DiscoveryJob) (map[string]any, error) {
+	progressJSON, err := json.Marshal(job.Progress)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling progress: %w", err)
+	}
+
+	var playlistJSON []byte
+	if job.Playlist != nil {
+		if playlistJSON, err = json.Marshal(job.Playlist); err != nil {
+			return nil, fmt.Errorf("marshalling playlist: %w", err)
+		}
+	}
+
+	return map[string]any{
+		"id":       job.ID,
+		"user_id":  job.UserID,
+		"dry_run":  job.DryRun,
+		"state":    job.State,
+		"progress": progressJSON,
+		"error":    sql.NullString{String: job.Error, Valid: job.Error != ""},
+		"playlist": playlistJSON,
+	}, nil
+}
+
+func (row discoveryJobRow) toDiscoveryJob() (recommendations.DiscoveryJob, error) {
+	job := recommendations.DiscoveryJob{
+		ID:     row.ID,
+		UserID: row.UserID,
+		DryRun: row.DryRun,
+		State:  row.State,
+		Error:  row.Error.String,
+	}
+
+	if err := json.Unmarshal(row.Progress, &job.Progress); err != nil {
+		return recommendations.DiscoveryJob{}, fmt.Errorf("unmarshalling progress: %w", err)
+	}
+
+	if len(row.Playlist) > 0 {
+		var playlist spotify.FullPlaylist
+		if err := json.Unmarshal(row.Playlist, &playlist); err != nil {
+			return recommendations.DiscoveryJob{}, fmt.Errorf("unmarshalling playlist: %w", err)
+		}
+		job.Playlist = &playlist
+	}
+
+	var err error
+	if job.CreatedAt, err = parseTime(row.InsertedAt); err != nil {
+		return recommendations.DiscoveryJob{}, fmt.Errorf("parsing inserted_at: %w", err)
+	}
+	if job.UpdatedAt, err = parseTime(row.UpdatedAt); err != nil {
+		return recommendations.DiscoveryJob{}, fmt.Errorf("parsing updated_at: %w", err)
+	}
+
+	return job, nil
+}
@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+func TestDiscoveryJobStoreFailUnfinished(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	this := NewDiscoveryJobStore(db, "this")
+	other := NewDiscoveryJobStore(db, "other")
+
+	jobs := []struct {
+		store *DiscoveryJobStore
+		job   recommendations.DiscoveryJob
+	}{
+		{this, recommendations.DiscoveryJob{ID: "interrupted", State: recommendations.JobStateScoring}},
+		{this, recommendations.DiscoveryJob{ID: "done", State: recommendations.JobStateDone}},
+		{other, recommendations.DiscoveryJob{ID: "running", State: recommendations.JobStateScoring}},
+		{other, recommendations.DiscoveryJob{ID: "stale", State: recommendations.JobStateQueued}},
+	}
+	for _, j := range jobs {
+		j.job.UserID = testUserID
+		if err := j.store.Create(ctx, j.job); err != nil {
+			t.Fatalf("creating job %s: %v", j.job.ID, err)
+		}
+	}
+	raw, release := db.Get(ctx)
+	raw.MustExec(`UPDATE discovery_jobs SET heartbeat_at = datetime('now', '-1 hour') WHERE id = 'stale'`)
+	release()
+
+	failed, err := this.FailUnfinished(ctx, recommendations.JobStaleAfter, "interrupted by server restart")
+	if err != nil {
+		t.Fatalf("FailUnfinished: %v", err)
+	}
+	if failed != 2 {
+		t.Errorf("FailUnfinished() failed %d jobs, want 2", failed)
+	}
+
+	want := map[string]string{
+		"interrupted": recommendations.JobStateFailed,
+		"done":        recommendations.JobStateDone,
+		"running":     recommendations.JobStateScoring,
+		"stale":       recommendations.JobStateFailed,
+	}
+	for id, state := range want {
+		job, exists, err := this.Get(ctx, testUserID, id)
+		if err != nil || !exists {
+			t.Fatalf("getting job %s: %t, %v", id, exists, err)
+		}
+		if job.State != state {
+			t.Errorf("job %s is %s, want %s", id, job.State, state)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+var _ recommendations.RecommendationStore = (*RecommendationStore)(nil)
+
+type RecommendationStore struct {
+	db *DB
+}
+
+func NewRecommendationStore(db *DB) *RecommendationStore {
+	return &RecommendationStore{db: db}
+}
+
+func (s *RecommendationStore) Record(ctx context.Context, userID, playlistName string, recommendedAt time.Time, tracks []recommendations.RecommendedTrack) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("beginning tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM recommended_tracks
+		WHERE user_id = ? AND playlist_name = ?
+	`, userID, playlistName); err != nil {
+		return fmt.Errorf("deleting previously recommended tracks of %s: %w", playlistName, err)
+	}
+
+	if len(tracks) > 0 {
+		rows := make([]map[string]any, 0, len(tracks))
+		for _, t := range tracks {
+			trackJSON, err := json.Marshal(t.Track)
+			if err != nil {
+				return fmt.Errorf("marshalling track: %w", err)
+			}
+			rows = append(rows, map[string]any{
+				"user_id":        userID,
+				"playlist_name":  playlistName,
+				"track_key":      t.Key,
+				"track_id":       t.TrackID,
+				"track_name":     t.Name,
+				"track":          trackJSON,
+				"recommended_at": formatTime(recommendedAt),
+			})
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+			INSERT OR REPLACE INTO recommended_tracks (user_id, playlist_name, track_key, track_id, track_name, track, recommended_at)
+			VALUES (:user_id, :playlist_name, :track_key, :track_id, :track_name, :track, :recommended_at)
+		`, rows); err != nil {
+			return fmt.Errorf("inserting recommended tracks of %s: %w", playlistName, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing tx: %w", err)
+	}
+	return nil
+}
+
+func (s *RecommendationStore) Previous(ctx context.Context, userID string, trackKeys []string, excludePlaylistName string) (map[string][]time.Time, error) {
+	previous := make(map[string][]time.Time)
+	if len(trackKeys) == 0 {
+		return previous, nil
+	}
+
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	query, args, err := sqlx.In(`
+		SELECT track_key, recommended_at
+		FROM recommended_tracks
+		WHERE user_id = ?
+			AND playlist_name != ?
+			AND track_key IN (?)
+		ORDER BY recommended_at DESC
+	`, userID, excludePlaylistName, trackKeys)
+	if err != nil {
+		return nil, fmt.Errorf("building previous recommendations query: %w", err)
+	}
+
+	var rows []struct {
+		TrackKey      string `db:"track_key"`
+		RecommendedAt string `db:"recommended_at"`
+	}
+	if err := db.SelectContext(ctx, &rows, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("querying previous recommendations for user %s: %w", userID, err)
+	}
+
+	for _, row := range rows {
+		recommendedAt, err := parseTime(row.RecommendedAt)
+		if err != nil {
+			return nil, fmt.Errorf("parsing recommended_at: %w", err)
+		}
+		previous[row.TrackKey] = append(previous[row.TrackKey], recommendedAt)
+	}
+	return previous, nil
+}
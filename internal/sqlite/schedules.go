@@ -0,0 +1,179 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+var _ recommendations.ScheduleStore = (*ScheduleStore)(nil)
+
+type ScheduleStore struct {
+	db *DB
+}
+
+func NewScheduleStore(db *DB) *ScheduleStore {
+	return &ScheduleStore{db: db}
+}
+
+type scheduleRow struct {
+	UserID         string         `db:"user_id"`
+	Enabled        bool           `db:"enabled"`
+	Weekday        int            `db:"weekday"`
+	Hour           int            `db:"hour"`
+	Minute         int            `db:"minute"`
+	Timezone       string         `db:"timezone"`
+	NextRunAt      string         `db:"next_run_at"`
+	LastRunAt      sql.NullString `db:"last_run_at"`
+	LastError      sql.NullString `db:"last_error"`
+	LastPlaylistID sql.NullString `db:"last_playlist_id"`
+	FailedAttempts int            `db:"failed_attempts"`
+}
+
+const scheduleColumns = `user_id, enabled, weekday, hour, minute, timezone, next_run_at, last_run_at, last_error, last_playlist_id, failed_attempts`
+
+func (s *ScheduleStore) GetSchedule(ctx context.Context, userID string) (recommendations.Schedule, bool, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var row scheduleRow
+	if err := db.GetContext(ctx, &row, `
+		SELECT `+scheduleColumns+`
+		FROM discovery_schedules
+		WHERE user_id = ?
+	`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return recommendations.Schedule{}, false, nil
+		}
+		return recommendations.Schedule{}, false, fmt.Errorf("querying schedule for user %s: %w", userID, err)
+	}
+
+	schedule, err := row.toSchedule()
+	if err != nil {
+		return recommendations.Schedule{}, false, fmt.Errorf("reading schedule for user %s: %w", userID, err)
+	}
+	return schedule, true, nil
+}
+
+func (s *ScheduleStore) PutSchedule(ctx context.Context, schedule recommendations.Schedule) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	var lastRunAt sql.NullString
+	if schedule.LastRunAt != nil {
+		lastRunAt = sql.NullString{String: formatTime(*schedule.LastRunAt), Valid: true}
+	}
+
+	if _, err := db.NamedExecContext(ctx, `
+		INSERT INTO discovery_schedules (`+scheduleColumns+`, updated_at)
+		VALUES (:user_id, :enabled, :weekday, :hour, :minute, :timezone, :next_run_at, :last_run_at, :last_error, :last_playlist_id, :failed_attempts, datetime('now'))
+		ON CONFLICT (user_id) DO UPDATE
+		SET enabled = excluded.enabled,
+			weekday = excluded.weekday,
+			hour = excluded.hour,
+			minute = excluded.minute,
+			timezone = excluded.timezone,
+			next_run_at = excluded.next_run_at,
+			last_run_at = excluded.last_run_at,
+			last_error = excluded.last_error,
+			last_playlist_id = excluded.last_playlist_id,
+			failed_attempts = excluded.failed_attempts,
+			updated_at = excluded.updated_at
+	`, scheduleRow{
+		UserID:         schedule.UserID,
+		Enabled:        schedule.Enabled,
+		Weekday:        int(schedule.Weekday),
+		Hour:           schedule.Hour,
+		Minute:         schedule.Minute,
+		Timezone:       schedule.Timezone,
+		NextRunAt:      formatTime(schedule.NextRunAt),
+		LastRunAt:      lastRunAt,
+		LastError:      sql.NullString{String: schedule.LastError, Valid: schedule.LastError != ""},
+		LastPlaylistID: sql.NullString{String: schedule.LastPlaylistID, Valid: schedule.LastPlaylistID != ""},
+		FailedAttempts: schedule.FailedAttempts,
+	}); err != nil {
+		return fmt.Errorf("storing schedule for user %s: %w", schedule.UserID, err)
+	}
+
+	return nil
+}
+
+func (s *ScheduleStore) DeleteSchedule(ctx context.Context, userID string) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM discovery_schedules
+		WHERE user_id = ?
+	`, userID); err != nil {
+		return fmt.Errorf("deleting schedule for user %s: %w", userID, err)
+	}
+
+	return nil
+}
+
+func (s *ScheduleStore) ListDueSchedules(ctx context.Context, now time.Time) ([]recommendations.Schedule, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var rows []scheduleRow
+	if err := db.SelectContext(ctx, &rows, `
+		SELECT `+scheduleColumns+`
+		FROM discovery_schedules
+		WHERE enabled AND next_run_at <= ?
+		ORDER BY next_run_at
+	`, formatTime(now)); err != nil {
+		return nil, fmt.Errorf("querying due schedules: %w", err)
+	}
+
+	schedules := make([]recommendations.Schedule, 0, len(rows))
+	for _, row := range rows {
+		schedule, err := row.toSchedule()
+		if err != nil {
+			return nil, fmt.Errorf("reading schedule for user %s: %w", row.UserID, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+func (row scheduleRow) toSchedule() (recommendations.Schedule, error) {
+	schedule := recommendations.Schedule{
+		UserID:         row.UserID,
+		Enabled:        row.Enabled,
+		Weekday:        time.Weekday(row.Weekday),
+		Hour:           row.Hour,
+		Minute:         row.Minute,
+		Timezone:       row.Timezone,
+		LastError:      row.LastError.String,
+		LastPlaylistID: row.LastPlaylistID.String,
+		FailedAttempts: row.FailedAttempts,
+	}
+
+	var err error
+	if schedule.NextRunAt, err = parseTime(row.NextRunAt); err != nil {
+		return recommendations.Schedule{}, fmt.Errorf("parsing next_run_at: %w", err)
+	}
+	if row.LastRunAt.Valid {
+		lastRunAt, err := parseTime(row.LastRunAt.String)
+		if err != nil {
+			return recommendations.Schedule{}, fmt.Errorf("parsing last_run_at: %w", err)
+		}
+		schedule.LastRunAt = &lastRunAt
+	}
+
+	return schedule, nil
+}
+
+// formatTime formats t the same way as SQLite's datetime() so they can be compared.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
+
+func parseTime(v string) (time.Time, error) {
+	return time.Parse(time.DateTime, v)
+}
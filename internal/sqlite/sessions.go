@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+var _ recommendations.SessionStore = (*SessionStore)(nil)
+
+type SessionStore struct {
+	db *DB
+}
+
+func NewSessionStore(db *DB) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+type sessionRow struct {
+	SessionID string `db:"session_id"`
+	UserID    string `db:"user_id"`
+	ExpiresAt string `db:"expires_at"`
+}
+
+func (s *SessionStore) CreateSession(ctx context.Context, session recommendations.Session) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	if _, err := db.NamedExecContext(ctx, `
+		INSERT INTO user_sessions (session_id, user_id, expires_at)
+		VALUES (:session_id, :user_id, :expires_at)
+	`, sessionRow{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		ExpiresAt: formatTime(session.ExpiresAt),
+	}); err != nil {
+		return fmt.Errorf("inserting session for user %s: %w", session.UserID, err)
+	}
+
+	// Piggyback on logins to clean up expired sessions.
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM user_sessions
+		WHERE expires_at <= datetime('now')
+	`); err != nil {
+		return fmt.Errorf("deleting expired sessions: %w", err)
+	}
+
+	return nil
+}
+
+// GetSession returns the session unless it doesn't exist or has expired.
+func (s *SessionStore) GetSession(ctx context.Context, sessionID string) (recommendations.Session, bool, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var row sessionRow
+	if err := db.GetContext(ctx, &row, `
+		SELECT session_id, user_id, expires_at
+		FROM user_sessions
+		WHERE session_id = ?
+			AND expires_at > datetime('now')
+	`, sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return recommendations.Session{}, false, nil
+		}
+		return recommendations.Session{}, false, fmt.Errorf("querying session: %w", err)
+	}
+
+	expiresAt, err := parseTime(row.ExpiresAt)
+	if err != nil {
+		return recommendations.Session{}, false, fmt.Errorf("parsing expires_at: %w", err)
+	}
+	return recommendations.Session{ID: row.SessionID, UserID: row.UserID, ExpiresAt: expiresAt}, true, nil
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+	"github.com/kristofferostlund/recommendli/pkg/secretbox"
+	"golang.org/x/oauth2"
+)
+
+var _ recommendations.TokenStore = (*TokenStore)(nil)
+
+// TokenStore stores the users' tokens encrypted, as they grant access to the
+// users' Spotify accounts for as long as the refresh token is valid.
+type TokenStore struct {
+	db  *DB
+	box *secretbox.Box
+}
+
+func NewTokenStore(db *DB, box *secretbox.Box) *TokenStore {
+	return &TokenStore{db: db, box: box}
+}
+
+func (s *TokenStore) GetToken(ctx context.Context, userID string) (*oauth2.Token, bool, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var sealed []byte
+	if err := db.GetContext(ctx, &sealed, `
+		SELECT token
+		FROM user_tokens
+		WHERE user_id = ?
+	`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("querying token for user %s: %w", userID, err)
+	}
+
+	b, err := s.box.Open(sealed)
+	if err != nil {
+		return nil, false, fmt.Errorf("decrypting token for user %s: %w", userID, err)
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(b, token); err != nil {
+		return nil, false, fmt.Errorf("unmarshalling token for user %s: %w", userID, err)
+	}
+
+	return token, true, nil
+}
+
+func (s *TokenStore) PutToken(ctx context.Context, userID string, token *oauth2.Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("marshalling token: %w", err)
+	}
+	sealed, err := s.box.Seal(b)
+	if err != nil {
+		return fmt.Errorf("encrypting token: %w", err)
+	}
+
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	if _, err := db.NamedExecContext(ctx, `
+		INSERT INTO user_tokens (user_id, token, updated_at)
+		VALUES (:user_id, :token, datetime('now'))
+		ON CONFLICT (user_id) DO UPDATE
+		SET token = excluded.token,
+			updated_at = excluded.updated_at
+	`, map[string]any{"user_id": userID, "token": sealed}); err != nil {
+		return fmt.Errorf("storing token for user %s: %w", userID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/kristofferostlund/recommendli/pkg/secretbox"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenStoreEncryptsTokens(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	key := make([]byte, secretbox.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	box, err := secretbox.New(key)
+	if err != nil {
+		t.Fatalf("creating secretbox: %v", err)
+	}
+	store := NewTokenStore(db, box)
+
+	if _, exists, err := store.GetToken(ctx, testUserID); err != nil || exists {
+		t.Fatalf("GetToken() before storing a token = %t, %v", exists, err)
+	}
+	if err := store.PutToken(ctx, testUserID, &oauth2.Token{AccessToken: "access-token", RefreshToken: "refresh-token"}); err != nil {
+		t.Fatalf("PutToken: %v", err)
+	}
+
+	raw, release := db.RGet(ctx)
+	var stored []byte
+	err = raw.GetContext(ctx, &stored, `SELECT token FROM user_tokens WHERE user_id = ?`, testUserID)
+	release()
+	if err != nil {
+		t.Fatalf("querying stored token: %v", err)
+	}
+	if bytes.Contains(stored, []byte("refresh-token")) {
+		t.Errorf("stored token contains the refresh token in plaintext")
+	}
+
+	token, exists, err := store.GetToken(ctx, testUserID)
+	if err != nil || !exists {
+		t.Fatalf("GetToken() = %t, %v", exists, err)
+	}
+	if token.RefreshToken != "refresh-token" {
+		t.Errorf("GetToken() refresh token = %q, want %q", token.RefreshToken, "refresh-token")
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/kristofferostlund/recommendli/internal/recommendations"
@@ -38,7 +42,7 @@ func NewTrackIndex(db *DB, trackIDFunc func(spotify.SimpleTrack) string) *TrackI
 	}
 }
 
-func (t *TrackIndex) Has(ctx context.Context, userID string, track spotify.SimpleTrack) (bool, error) {
+func (t *TrackIndex) Has(ctx context.Context, userID string, track spotify.FullTrack) (bool, error) {
 	playlists, err := t.Lookup(ctx, userID, track)
 	if err != nil {
 		return false, fmt.Errorf("looking up track in index: %w", err)
@@ -46,16 +50,37 @@ func (t *TrackIndex) Has(ctx context.Context, userID string, track spotify.Simpl
 	return len(playlists) > 0, nil
 }
 
-func (t *TrackIndex) Lookup(ctx context.Context, userID string, track spotify.SimpleTrack) ([]spotify.SimplePlaylist, error) {
+// Lookup returns the playlists the track is on. Tracks are matched by ISRC when
+// there is one, falling back to the track key.
+func (t *TrackIndex) Lookup(ctx context.Context, userID string, track spotify.FullTrack) ([]spotify.SimplePlaylist, error) {
 	db, release := t.db.RGet(ctx)
 	defer release()
 
-	values := map[string]any{
-		"track_key": t.trackIDFunc(track),
-		"user_id":   userID,
+	if isrc := trackISRC(track); isrc != "" {
+		playlists, err := queryTrackPlaylists(ctx, db, `
+			SELECT DISTINCT tp.simple_playlist
+			FROM trackindex_playlists AS tp
+			INNER JOIN
+				trackindex_playlist_tracks AS tpt
+				ON tp.id = tpt.playlist_id
+					AND tp.user_id = tpt.user_id
+			INNER JOIN
+				trackindex_tracks AS tt
+				ON tt.key = tpt.track_key
+					AND tt.user_id = tpt.user_id
+			WHERE
+				tt.isrc = :isrc
+				AND tp.user_id = :user_id
+		`, map[string]any{"isrc": isrc, "user_id": userID})
+		if err != nil {
+			return nil, fmt.Errorf("looking up track playlists for track %s (%s) by isrc: %w", track.Name, track.ID, err)
+		}
+		if len(playlists) > 0 {
+			return playlists, nil
+		}
 	}
 
-	rows, err := db.NamedQueryContext(ctx, `
+	playlists, err := queryTrackPlaylists(ctx, db, `
 		SELECT tp.simple_playlist
 		FROM trackindex_playlists AS tp
 		INNER JOIN
@@ -65,10 +90,18 @@ func (t *TrackIndex) Lookup(ctx context.Context, userID string, track spotify.Si
 		WHERE
 			tpt.track_key = :track_key
 			AND tp.user_id = :user_id
-	`, values)
+	`, map[string]any{"track_key": t.trackIDFunc(track.SimpleTrack), "user_id": userID})
 	if err != nil {
 		return nil, fmt.Errorf("looking up track playlists for track %s (%s): %w", track.Name, track.ID, err)
 	}
+	return playlists, nil
+}
+
+func queryTrackPlaylists(ctx context.Context, db *sqlx.DB, query string, values map[string]any) ([]spotify.SimplePlaylist, error) {
+	rows, err := db.NamedQueryContext(ctx, query, values)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	var playlists []spotify.SimplePlaylist
@@ -141,7 +174,7 @@ func (t *TrackIndex) Diff(ctx context.Context, userID string, playlists []spotif
 	return addedPlaylists, changedPlaylists, removedPlaylists, nil
 }
 
-func (t *TrackIndex) Sync(ctx context.Context, userID string, added, changed, removed []spotify.FullPlaylist) error {
+func (t *TrackIndex) Sync(ctx context.Context, userID string, added, changed []spotify.FullPlaylist, removed []spotify.SimplePlaylist) (recommendations.SyncStats, error) {
 	ctx = slogutil.WithAttrs(ctx, slog.String("user", userID), slog.Int("added", len(added)), slog.Int("changed", len(changed)), slog.Int("removed", len(removed)))
 	slog.DebugContext(ctx, "syncing track index")
 
@@ -152,106 +185,353 @@ func (t *TrackIndex) Sync(ctx context.Context, userID string, added, changed, re
 
 	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
-		return fmt.Errorf("beginning tx: %w", err)
+		return recommendations.SyncStats{}, fmt.Errorf("beginning tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	slog.DebugContext(ctx, "inserting added playlists")
-	for _, playlist := range added {
-		if err := t.addPlaylistToTrackIndex(ctx, tx, userID, playlist); err != nil {
-			return fmt.Errorf("adding playlist %s (%s) to track index: %w", playlist.Name, playlist.ID, err)
-		}
+	stats := recommendations.SyncStats{
+		PlaylistsAdded:   len(added),
+		PlaylistsChanged: len(changed),
+		PlaylistsRemoved: len(removed),
 	}
 
-	slog.DebugContext(ctx, "inserting changed playlists")
-	for _, playlist := range changed {
-		// I can improve this, but for now I don't think it's worth it.
-		// A remove and re-add is a simple way to handle this.
-		slog.DebugContext(ctx, "removing updated playlist so it can be re-added", slog.String("playlist_id", playlist.ID.String()), slog.String("playlist_name", playlist.Name))
-		if err := removeTrackIndexPlaylist(ctx, tx, userID, playlist.ID); err != nil {
-			return fmt.Errorf("removing playlist %s (%s) from track index: %w", playlist.Name, playlist.ID, err)
-		}
-
-		slog.DebugContext(ctx, "adding updated playlist again", slog.String("playlist_id", playlist.ID.String()), slog.String("playlist_name", playlist.Name))
-		if err := t.addPlaylistToTrackIndex(ctx, tx, userID, playlist); err != nil {
-			return fmt.Errorf("re-adding playlist %s (%s) to track index: %w", playlist.Name, playlist.ID, err)
+	slog.DebugContext(ctx, "inserting added and changed playlists")
+	for _, playlist := range append(added, changed...) {
+		slog.DebugContext(ctx, "syncing playlist", slog.String("playlist_id", playlist.ID.String()), slog.String("playlist_name", playlist.Name))
+		tracksAdded, tracksRemoved, err := t.syncPlaylist(ctx, tx, userID, playlist)
+		if err != nil {
+			return recommendations.SyncStats{}, fmt.Errorf("syncing playlist %s (%s) to track index: %w", playlist.Name, playlist.ID, err)
 		}
+		stats.PlaylistTracksAdded += tracksAdded
+		stats.PlaylistTracksRemoved += tracksRemoved
 	}
 
 	slog.DebugContext(ctx, "removing removed playlists")
 	for _, playlist := range removed {
 		slog.DebugContext(ctx, "removing playlist", slog.String("playlist_id", playlist.ID.String()), slog.String("playlist_name", playlist.Name))
-		if err := removeTrackIndexPlaylist(ctx, tx, userID, playlist.ID); err != nil {
-			return fmt.Errorf("removing playlist %s (%s) from track index: %w", playlist.Name, playlist.ID, err)
+		tracksRemoved, err := removeTrackIndexPlaylist(ctx, tx, userID, playlist)
+		if err != nil {
+			return recommendations.SyncStats{}, fmt.Errorf("removing playlist %s (%s) from track index: %w", playlist.Name, playlist.ID, err)
+		}
+		stats.PlaylistTracksRemoved += tracksRemoved
+	}
+
+	if stats.PlaylistTracksRemoved > 0 {
+		slog.DebugContext(ctx, "removing orphaned tracks")
+		if stats.TracksOrphaned, err = removeOrphanedTracks(ctx, tx, userID); err != nil {
+			return recommendations.SyncStats{}, err
 		}
 	}
 
 	slog.DebugContext(ctx, "committing tx")
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("committing tx: %w", err)
+		return recommendations.SyncStats{}, fmt.Errorf("committing tx: %w", err)
 	}
 
-	slog.DebugContext(ctx, "synced track index")
+	slog.DebugContext(ctx, "synced track index", slog.Int("tracks_added", stats.PlaylistTracksAdded), slog.Int("tracks_removed", stats.PlaylistTracksRemoved), slog.Int("tracks_orphaned", stats.TracksOrphaned))
 
-	return nil
+	return stats, nil
 }
 
-func (t *TrackIndex) addPlaylistToTrackIndex(ctx context.Context, q Querier, userID string, playlist spotify.FullPlaylist) error {
+// syncPlaylist upserts the playlist and diffs its tracks against the ones in the
+// index, only writing the playlist's tracks which differ. Tracks which are still
+// on the playlist are left alone, unless they were indexed without the ISRC
+// they now have, in which case it's filled in.
+func (t *TrackIndex) syncPlaylist(ctx context.Context, q Querier, userID string, playlist spotify.FullPlaylist) (added, removed int, err error) {
 	if err := insertOrReplaceTrackIndexPlaylists(ctx, q, userID, playlist.SimplePlaylist); err != nil {
-		return fmt.Errorf("inserting playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+		return 0, 0, fmt.Errorf("inserting playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	}
+
+	var prevRows []struct {
+		TrackKey    string `db:"track_key"`
+		MissingISRC bool   `db:"missing_isrc"`
+	}
+	if err := sqlx.SelectContext(ctx, q, &prevRows, `
+		SELECT
+			tpt.track_key,
+			tt.isrc IS NULL AS missing_isrc
+		FROM trackindex_playlist_tracks AS tpt
+		LEFT JOIN
+			trackindex_tracks AS tt
+			ON tt.key = tpt.track_key
+				AND tt.user_id = tpt.user_id
+		WHERE tpt.playlist_id = ? AND tpt.user_id = ?
+	`, playlist.ID.String(), userID); err != nil {
+		return 0, 0, fmt.Errorf("querying playlist tracks: %w", err)
+	}
+	prevKeys := make([]string, 0, len(prevRows))
+	prev := make(map[string]bool, len(prevRows))
+	missingISRC := make(map[string]bool)
+	for _, row := range prevRows {
+		prevKeys = append(prevKeys, row.TrackKey)
+		prev[row.TrackKey] = true
+		if row.MissingISRC {
+			missingISRC[row.TrackKey] = true
+		}
 	}
 
-	simpleTracks := make([]spotify.SimpleTrack, 0, len(playlist.Tracks.Tracks))
+	now := time.Now()
+	next := make(map[string]bool, len(playlist.Tracks.Tracks))
+	tracks := make([]spotify.FullTrack, 0)
+	addedKeys := make([]string, 0)
+	addedEvents := make([]recommendations.TrackEvent, 0)
 	for _, track := range playlist.Tracks.Tracks {
-		simpleTracks = append(simpleTracks, track.Track.SimpleTrack)
+		key := t.trackIDFunc(track.Track.SimpleTrack)
+		if next[key] {
+			continue
+		}
+		next[key] = true
+		if prev[key] {
+			if missingISRC[key] && trackISRC(track.Track) != "" {
+				tracks = append(tracks, track.Track)
+			}
+		} else {
+			tracks = append(tracks, track.Track)
+			addedKeys = append(addedKeys, key)
+			addedEvents = append(addedEvents, recommendations.TrackEvent{
+				Type:         recommendations.TrackEventAdded,
+				PlaylistID:   playlist.ID.String(),
+				PlaylistName: playlist.Name,
+				TrackKey:     key,
+				TrackName:    track.Track.Name,
+				OccurredAt:   playlistTrackAddedAt(track, now),
+			})
+		}
+	}
+
+	removedKeys := make([]string, 0)
+	for _, key := range prevKeys {
+		if !next[key] {
+			removedKeys = append(removedKeys, key)
+		}
+	}
+
+	if err := t.upsertTrackIndexTracks(ctx, q, userID, tracks); err != nil {
+		return 0, 0, fmt.Errorf("upserting tracks for playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	}
+	if err := insertTrackIndexPlaylistTracks(ctx, q, userID, playlist.ID.String(), addedKeys); err != nil {
+		return 0, 0, fmt.Errorf("inserting tracks for playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	}
+	if err := insertTrackEvents(ctx, q, userID, addedEvents); err != nil {
+		return 0, 0, fmt.Errorf("inserting added events for playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	}
+	// The removed events are recorded before deleting, as the track names are read
+	// from the index.
+	if err := insertRemovedTrackEvents(ctx, q, userID, playlist.SimplePlaylist, removedKeys, now); err != nil {
+		return 0, 0, fmt.Errorf("inserting removed events for playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	}
+	if err := deleteTrackIndexTracksOnPlaylist(ctx, q, userID, playlist.ID.String(), removedKeys); err != nil {
+		return 0, 0, fmt.Errorf("deleting tracks for playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	}
+
+	return len(addedKeys), len(removedKeys), nil
+}
+
+// playlistTrackAddedAt returns when the track was added to the playlist, falling
+// back to fallback for very old playlists which Spotify has no dates for.
+func playlistTrackAddedAt(track spotify.PlaylistTrack, fallback time.Time) time.Time {
+	addedAt, err := time.Parse(spotify.TimestampLayout, track.AddedAt)
+	if err != nil || addedAt.IsZero() || addedAt.Year() < 2000 {
+		return fallback
+	}
+	return addedAt
+}
+
+func insertTrackEvents(ctx context.Context, q Querier, userID string, events []recommendations.TrackEvent) error {
+	if len(events) == 0 {
+		return nil
 	}
 
-	if err := t.insertTrackIndexTrackOnPlaylist(ctx, q, userID, playlist.ID.String(), simpleTracks); err != nil {
-		return fmt.Errorf("inserting tracks for playlist %s (%s): %w", playlist.Name, playlist.ID, err)
+	rows := make([]map[string]any, 0, len(events))
+	for _, e := range events {
+		rows = append(rows, map[string]any{
+			"user_id":       userID,
+			"type":          e.Type,
+			"playlist_id":   e.PlaylistID,
+			"playlist_name": e.PlaylistName,
+			"track_key":     e.TrackKey,
+			"track_name":    e.TrackName,
+			"occurred_at":   formatTime(e.OccurredAt),
+		})
 	}
 
+	if _, err := q.NamedExecContext(ctx, `
+		INSERT INTO trackindex_events (user_id, type, playlist_id, playlist_name, track_key, track_name, occurred_at)
+		VALUES (:user_id, :type, :playlist_id, :playlist_name, :track_key, :track_name, :occurred_at)
+	`, rows); err != nil {
+		return fmt.Errorf("inserting track events: %w", err)
+	}
 	return nil
 }
 
-func removeTrackIndexPlaylist(ctx context.Context, q Querier, userID string, playlistID spotify.ID) error {
+const insertRemovedTrackEventsQuery = `
+	INSERT INTO trackindex_events (user_id, type, playlist_id, playlist_name, track_key, track_name, occurred_at)
+	SELECT
+		tpt.user_id,
+		?,
+		tpt.playlist_id,
+		?,
+		tpt.track_key,
+		tt.name,
+		?
+	FROM trackindex_playlist_tracks AS tpt
+	INNER JOIN
+		trackindex_tracks AS tt
+		ON tt.key = tpt.track_key
+			AND tt.user_id = tpt.user_id
+	WHERE
+		tpt.playlist_id = ?
+		AND tpt.user_id = ?
+`
+
+// insertRemovedTrackEvents records the removal of the playlist's tracks with the
+// given keys.
+func insertRemovedTrackEvents(ctx context.Context, q Querier, userID string, playlist spotify.SimplePlaylist, trackKeys []string, occurredAt time.Time) error {
+	for from := 0; from < len(trackKeys); from += upsertBatchSize {
+		query, args, err := sqlx.In(insertRemovedTrackEventsQuery+`
+			AND tpt.track_key IN (?)
+		`, recommendations.TrackEventRemoved, playlist.Name, formatTime(occurredAt), playlist.ID.String(), userID, trackKeys[from:min(from+upsertBatchSize, len(trackKeys))])
+		if err != nil {
+			return fmt.Errorf("building query: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("inserting removed track events: %w", err)
+		}
+	}
+	return nil
+}
+
+// insertRemovedPlaylistEvents records the removal of all of the playlist's tracks.
+func insertRemovedPlaylistEvents(ctx context.Context, q Querier, userID string, playlist spotify.SimplePlaylist, occurredAt time.Time) error {
+	if _, err := q.ExecContext(ctx, insertRemovedTrackEventsQuery, recommendations.TrackEventRemoved, playlist.Name, formatTime(occurredAt), playlist.ID.String(), userID); err != nil {
+		return fmt.Errorf("inserting removed track events: %w", err)
+	}
+	return nil
+}
+
+func removeTrackIndexPlaylist(ctx context.Context, q Querier, userID string, playlist spotify.SimplePlaylist) (int, error) {
+	playlistID := playlist.ID
+	if err := insertRemovedPlaylistEvents(ctx, q, userID, playlist, time.Now()); err != nil {
+		return 0, err
+	}
+
 	// Remove the playlist
 	if _, err := q.NamedExecContext(ctx, `
 		DELETE FROM trackindex_playlists
 		WHERE id = :id AND user_id = :user_id
 	`, map[string]any{"id": playlistID, "user_id": userID}); err != nil {
-		return fmt.Errorf("deleting playlist from track index: %w", err)
+		return 0, fmt.Errorf("deleting playlist from track index: %w", err)
 	}
 
-	// Remove playlist tracks
-	if _, err := q.NamedExecContext(ctx, `
+	// Remove playlist tracks, the tracks themselves are removed in removeOrphanedTracks
+	res, err := q.NamedExecContext(ctx, `
 		DELETE FROM trackindex_playlist_tracks
 		WHERE playlist_id = :playlist_id AND user_id = :user_id
-	`, map[string]any{"playlist_id": playlistID, "user_id": userID}); err != nil {
-		return fmt.Errorf("deleting playlist tracks from track index: %w", err)
+	`, map[string]any{"playlist_id": playlistID, "user_id": userID})
+	if err != nil {
+		return 0, fmt.Errorf("deleting playlist tracks from track index: %w", err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("counting deleted playlist tracks: %w", err)
 	}
 
-	// Remove tracks that are no longer on any playlists
-	if _, err := q.QueryContext(ctx, `
+	return int(removed), nil
+}
+
+// removeOrphanedTracks removes the user's tracks which are no longer on any playlists.
+func removeOrphanedTracks(ctx context.Context, q Querier, userID string) (int, error) {
+	res, err := q.ExecContext(ctx, `
 		DELETE FROM trackindex_tracks
-		WHERE NOT EXISTS (
-			SELECT 1
-			FROM trackindex_playlist_tracks
-			WHERE trackindex_playlist_tracks.track_key = trackindex_tracks.key
-		)
-	`); err != nil {
-		return fmt.Errorf("deleting orphaned tracks from track index: %w", err)
+		WHERE user_id = ?
+			AND NOT EXISTS (
+				SELECT 1
+				FROM trackindex_playlist_tracks
+				WHERE trackindex_playlist_tracks.track_key = trackindex_tracks.key
+					AND trackindex_playlist_tracks.user_id = trackindex_tracks.user_id
+			)
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("deleting orphaned tracks from track index: %w", err)
 	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("counting deleted orphaned tracks: %w", err)
+	}
+
+	if _, err := q.ExecContext(ctx, `
+		DELETE FROM trackindex_track_artists
+		WHERE user_id = ?
+			AND NOT EXISTS (
+				SELECT 1
+				FROM trackindex_tracks
+				WHERE trackindex_tracks.key = trackindex_track_artists.track_key
+					AND trackindex_tracks.user_id = trackindex_track_artists.user_id
+			)
+	`, userID); err != nil {
+		return 0, fmt.Errorf("deleting orphaned track-artists from track index: %w", err)
+	}
+	if _, err := q.ExecContext(ctx, `
+		DELETE FROM trackindex_artists
+		WHERE user_id = ?
+			AND NOT EXISTS (
+				SELECT 1
+				FROM trackindex_track_artists
+				WHERE trackindex_track_artists.artist_id = trackindex_artists.id
+					AND trackindex_track_artists.user_id = trackindex_artists.user_id
+			)
+	`, userID); err != nil {
+		return 0, fmt.Errorf("deleting orphaned artists from track index: %w", err)
+	}
+
+	return int(removed), nil
+}
 
+func deleteTrackIndexTracksOnPlaylist(ctx context.Context, q Querier, userID string, playlistID string, trackKeys []string) error {
+	for from := 0; from < len(trackKeys); from += upsertBatchSize {
+		query, args, err := sqlx.In(`
+			DELETE FROM trackindex_playlist_tracks
+			WHERE playlist_id = ? AND user_id = ? AND track_key IN (?)
+		`, playlistID, userID, trackKeys[from:min(from+upsertBatchSize, len(trackKeys))])
+		if err != nil {
+			return fmt.Errorf("building query: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("deleting playlist tracks from track index: %w", err)
+		}
+	}
 	return nil
 }
 
-func (t *TrackIndex) insertTrackIndexTrackOnPlaylist(ctx context.Context, q Querier, userID string, playlistID string, tracks []spotify.SimpleTrack) error {
+// upsertBatchSize keeps the number of variables of batch upserts well below
+// SQLite's limit, even for playlists with thousands of tracks.
+const upsertBatchSize = 1000
+
+// upsertTrackIndexTracks inserts the tracks and their artists, or updates them
+// when they're already indexed, e.g. by another playlist, so they're stored as
+// last fetched from Spotify.
+func (t *TrackIndex) upsertTrackIndexTracks(ctx context.Context, q Querier, userID string, tracks []spotify.FullTrack) error {
+	for from := 0; from < len(tracks); from += upsertBatchSize {
+		if err := t.upsertTrackIndexTrackBatch(ctx, q, userID, tracks[from:min(from+upsertBatchSize, len(tracks))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TrackIndex) upsertTrackIndexTrackBatch(ctx context.Context, q Querier, userID string, tracks []spotify.FullTrack) error {
 	trackRows := make([]map[string]any, 0, len(tracks))
-	playlistTrackRows := make([]map[string]any, 0, len(tracks))
+	artistRows := make([]map[string]any, 0, len(tracks))
+	trackArtistRows := make([]map[string]any, 0, len(tracks))
 
 	for _, track := range tracks {
-		trackKey := t.trackIDFunc(track)
+		trackKey := t.trackIDFunc(track.SimpleTrack)
+
+		var isrc sql.NullString
+		if v := trackISRC(track); v != "" {
+			isrc = sql.NullString{String: v, Valid: true}
+		}
 
 		trackJSON, err := json.Marshal(track)
 		if err != nil {
@@ -261,45 +541,103 @@ func (t *TrackIndex) insertTrackIndexTrackOnPlaylist(ctx context.Context, q Quer
 		trackRows = append(trackRows, map[string]any{
 			"key":          trackKey,
 			"name":         track.Name,
-			"simple_track": trackJSON,
+			"artist_names": trackArtistNames(track.SimpleTrack),
+			"track":        trackJSON,
+			"isrc":         isrc,
 			"user_id":      userID,
 		})
 
-		playlistTrackRows = append(playlistTrackRows, map[string]any{
-			"playlist_id": playlistID,
-			"track_key":   trackKey,
-			"user_id":     userID,
-		})
+		for _, artist := range track.Artists {
+			// Local files have artists without IDs.
+			if artist.ID == "" {
+				continue
+			}
+			artistRows = append(artistRows, map[string]any{
+				"id":      artist.ID.String(),
+				"name":    artist.Name,
+				"user_id": userID,
+			})
+			trackArtistRows = append(trackArtistRows, map[string]any{
+				"track_key": trackKey,
+				"artist_id": artist.ID.String(),
+				"user_id":   userID,
+			})
+		}
 	}
 
 	if _, err := q.NamedExecContext(ctx, `
-		INSERT OR REPLACE INTO trackindex_tracks (
+		INSERT INTO trackindex_tracks (
 			key,
 			name,
-			simple_track,
-			user_id,
-			updated_at
+			artist_names,
+			track,
+			isrc,
+			user_id
 		)
-		VALUES (:key, :name, :simple_track, :user_id, datetime('now'))
+		VALUES (:key, :name, :artist_names, :track, :isrc, :user_id)
+		ON CONFLICT (key, user_id) DO UPDATE
+		SET name = excluded.name,
+			artist_names = excluded.artist_names,
+			track = excluded.track,
+			isrc = excluded.isrc,
+			updated_at = datetime('now')
 	`, trackRows); err != nil {
-		return fmt.Errorf("inserting tracks into track index: %w", err)
+		return fmt.Errorf("upserting tracks into track index: %w", err)
+	}
+
+	if len(artistRows) == 0 {
+		return nil
 	}
 
 	if _, err := q.NamedExecContext(ctx, `
-		INSERT OR REPLACE INTO trackindex_playlist_tracks (
-			playlist_id,
-			track_key,
-			user_id,
-			updated_at
-		)
-		VALUES (:playlist_id, :track_key, :user_id, datetime('now'))
-	`, playlistTrackRows); err != nil {
-		return fmt.Errorf("inserting playlist-tracks into track index: %w", err)
+		INSERT INTO trackindex_artists (id, name, user_id)
+		VALUES (:id, :name, :user_id)
+		ON CONFLICT (id, user_id) DO UPDATE
+		SET name = excluded.name,
+			updated_at = datetime('now')
+	`, artistRows); err != nil {
+		return fmt.Errorf("inserting artists into track index: %w", err)
+	}
+
+	if _, err := q.NamedExecContext(ctx, `
+		INSERT OR IGNORE INTO trackindex_track_artists (track_key, artist_id, user_id)
+		VALUES (:track_key, :artist_id, :user_id)
+	`, trackArtistRows); err != nil {
+		return fmt.Errorf("inserting track-artists into track index: %w", err)
 	}
 
 	return nil
 }
 
+func insertTrackIndexPlaylistTracks(ctx context.Context, q Querier, userID string, playlistID string, trackKeys []string) error {
+	if len(trackKeys) == 0 {
+		return nil
+	}
+
+	rows := make([]map[string]any, 0, len(trackKeys))
+	for _, key := range trackKeys {
+		rows = append(rows, map[string]any{
+			"playlist_id": playlistID,
+			"track_key":   key,
+			"user_id":     userID,
+		})
+	}
+	for from := 0; from < len(rows); from += upsertBatchSize {
+		if _, err := q.NamedExecContext(ctx, `
+			INSERT OR REPLACE INTO trackindex_playlist_tracks (
+				playlist_id,
+				track_key,
+				user_id,
+				updated_at
+			)
+			VALUES (:playlist_id, :track_key, :user_id, datetime('now'))
+		`, rows[from:min(from+upsertBatchSize, len(rows))]); err != nil {
+			return fmt.Errorf("inserting playlist-tracks into track index: %w", err)
+		}
+	}
+	return nil
+}
+
 func insertOrReplaceTrackIndexPlaylists(ctx context.Context, q Querier, userID string, playlist spotify.SimplePlaylist) error {
 	playlistJSON, err := json.Marshal(playlist)
 	if err != nil {
@@ -329,33 +667,78 @@ func insertOrReplaceTrackIndexPlaylists(ctx context.Context, q Querier, userID s
 	return nil
 }
 
-func (t *TrackIndex) CountTracksByArtist(ctx context.Context, userID string, artistName string) (int, error) {
+func (t *TrackIndex) CountTracksByArtists(ctx context.Context, userID string, artistIDs []spotify.ID) (map[spotify.ID]int, error) {
+	counts := make(map[spotify.ID]int)
+	if len(artistIDs) == 0 {
+		return counts, nil
+	}
+
 	db, release := t.db.RGet(ctx)
 	defer release()
 
-	var count int
-	if err := db.GetContext(ctx, &count, `
-		SELECT COUNT(*)
-		FROM trackindex_tracks
-		CROSS JOIN json_each(simple_track, '$.artists') AS artist
+	rows, err := artistTrackCounts(ctx, db, userID, artistIDs, len(artistIDs))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[spotify.ID(row.ID)] = row.TrackCount
+	}
+	return counts, nil
+}
+
+// artistTrackCounts counts the user's tracks per artist, limited to artistIDs
+// unless it's empty, with the artists with the most tracks first.
+func artistTrackCounts(ctx context.Context, q sqlx.QueryerContext, userID string, artistIDs []spotify.ID, limit int) ([]recommendations.ArtistTrackCount, error) {
+	ids := []string{""}
+	for _, id := range artistIDs {
+		ids = append(ids, id.String())
+	}
+	query, args, err := sqlx.In(`
+		SELECT
+			ta.artist_id,
+			a.name AS artist_name,
+			COUNT(*) AS track_count
+		FROM trackindex_track_artists AS ta
+		INNER JOIN
+			trackindex_artists AS a
+			ON a.id = ta.artist_id
+				AND a.user_id = ta.user_id
 		WHERE
-			json_extract(artist.value, '$.name') = ?
-			AND user_id = ?
-	`, artistName, userID); err != nil {
-		return 0, fmt.Errorf("counting tracks by artist (%s): %w", artistName, err)
+			ta.user_id = ?
+			AND (? OR ta.artist_id IN (?))
+		GROUP BY ta.artist_id
+		ORDER BY track_count DESC, artist_name
+		LIMIT ?
+	`, userID, len(artistIDs) == 0, ids, limit)
+	if err != nil {
+		return nil, fmt.Errorf("building query: %w", err)
 	}
 
-	return count, nil
+	var rows []struct {
+		ArtistID   string `db:"artist_id"`
+		Name       string `db:"artist_name"`
+		TrackCount int    `db:"track_count"`
+	}
+	if err := sqlx.SelectContext(ctx, q, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("querying track counts by artist: %w", err)
+	}
+
+	counts := make([]recommendations.ArtistTrackCount, 0, len(rows))
+	for _, row := range rows {
+		counts = append(counts, recommendations.ArtistTrackCount{ID: row.ArtistID, Name: row.Name, TrackCount: row.TrackCount})
+	}
+	return counts, nil
 }
 
+const topArtistsLimit = 25
+
 func (t *TrackIndex) Summarize(ctx context.Context, userID string) (recommendations.IndexSummary, error) {
-	// TODO: Get the track count to work 🤷
 	db, release := t.db.RGet(ctx)
 	defer release()
 
-	var uniqueTrackCount int
+	summary := recommendations.IndexSummary{AlbumTypes: make(map[string]int)}
 
-	if err := db.GetContext(ctx, &uniqueTrackCount, `
+	if err := db.GetContext(ctx, &summary.UniqueTrackCount, `
 		SELECT COUNT(*)
 		FROM trackindex_tracks
 		WHERE user_id = ?
@@ -373,7 +756,6 @@ func (t *TrackIndex) Summarize(ctx context.Context, userID string) (recommendati
 	}
 	defer trackRows.Close()
 
-	var playlists []spotify.SimplePlaylist
 	for trackRows.Next() {
 		var b []byte
 		if err := trackRows.Scan(&b); err != nil {
@@ -384,12 +766,606 @@ func (t *TrackIndex) Summarize(ctx context.Context, userID string) (recommendati
 		if err := json.Unmarshal(b, &playlist); err != nil {
 			return recommendations.IndexSummary{}, fmt.Errorf("unmarshalling playlist: %w", err)
 		}
-		playlists = append(playlists, playlist)
+		summary.Playlists = append(summary.Playlists, playlist)
 	}
+	summary.PlaylistCount = len(summary.Playlists)
 
-	return recommendations.IndexSummary{
-		UniqueTrackCount: uniqueTrackCount,
-		PlaylistCount:    len(playlists),
-		Playlists:        playlists,
+	if summary.TopArtists, err = artistTrackCounts(ctx, db, userID, nil, topArtistsLimit); err != nil {
+		return recommendations.IndexSummary{}, err
+	}
+
+	var playlistCounts []struct {
+		ID         string `db:"id"`
+		Name       string `db:"name"`
+		TrackCount int    `db:"track_count"`
+	}
+	if err := db.SelectContext(ctx, &playlistCounts, `
+		SELECT
+			tp.id,
+			tp.name,
+			COUNT(tpt.track_key) AS track_count
+		FROM trackindex_playlists AS tp
+		LEFT JOIN
+			trackindex_playlist_tracks AS tpt
+			ON tp.id = tpt.playlist_id
+				AND tp.user_id = tpt.user_id
+		WHERE tp.user_id = ?
+		GROUP BY tp.id
+		ORDER BY tp.name
+	`, userID); err != nil {
+		return recommendations.IndexSummary{}, fmt.Errorf("querying track index for tracks per playlist: %w", err)
+	}
+	summary.TracksPerPlaylist = make([]recommendations.PlaylistTrackCount, 0, len(playlistCounts))
+	for _, p := range playlistCounts {
+		summary.TracksPerPlaylist = append(summary.TracksPerPlaylist, recommendations.PlaylistTrackCount{ID: p.ID, Name: p.Name, TrackCount: p.TrackCount})
+		summary.TrackCount += p.TrackCount
+	}
+
+	var yearCounts []struct {
+		Year       int `db:"year"`
+		TrackCount int `db:"track_count"`
+	}
+	if err := db.SelectContext(ctx, &yearCounts, `
+		SELECT
+			CAST(substr(json_extract(track, '$.album.release_date'), 1, 4) AS INTEGER) AS year,
+			COUNT(*) AS track_count
+		FROM trackindex_tracks
+		WHERE
+			user_id = ?
+			AND json_extract(track, '$.album.release_date') IS NOT NULL
+		GROUP BY year
+		ORDER BY year
+	`, userID); err != nil {
+		return recommendations.IndexSummary{}, fmt.Errorf("querying track index for release years: %w", err)
+	}
+	summary.ReleaseYears = make([]recommendations.ReleaseYearCount, 0, len(yearCounts))
+	for _, y := range yearCounts {
+		summary.ReleaseYears = append(summary.ReleaseYears, recommendations.ReleaseYearCount{Year: y.Year, TrackCount: y.TrackCount})
+	}
+
+	var albumTypes []struct {
+		AlbumType  string `db:"album_type"`
+		TrackCount int    `db:"track_count"`
+	}
+	if err := db.SelectContext(ctx, &albumTypes, `
+		SELECT
+			COALESCE(json_extract(track, '$.album.album_type'), 'unknown') AS album_type,
+			COUNT(*) AS track_count
+		FROM trackindex_tracks
+		WHERE user_id = ?
+		GROUP BY album_type
+	`, userID); err != nil {
+		return recommendations.IndexSummary{}, fmt.Errorf("querying track index for album types: %w", err)
+	}
+	for _, a := range albumTypes {
+		summary.AlbumTypes[a.AlbumType] = a.TrackCount
+	}
+
+	return summary, nil
+}
+
+// Rekey recomputes the key of every indexed track, which is needed whenever the
+// function tracks are keyed by changes. keyedBy names that function, such as the
+// match strictness, and the index is only rekeyed when it differs from the one
+// the index was last keyed by. Tracks which end up with the same key are merged
+// into one. Blocklisted and recommended tracks are rekeyed along with them.
+// It returns the number of indexed tracks which got a new key.
+func (t *TrackIndex) Rekey(ctx context.Context, keyedBy string) (int, error) {
+	db, release := t.db.Get(ctx)
+	defer release()
+
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, fmt.Errorf("beginning tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var lastKeyedBy string
+	if err := tx.GetContext(ctx, &lastKeyedBy, `
+		SELECT keyed_by
+		FROM trackindex_keying
+		WHERE id = 1
+	`); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("querying what the index was keyed by: %w", err)
+	}
+	if lastKeyedBy == keyedBy {
+		return 0, nil
+	}
+
+	var rows []struct {
+		Key    string `db:"key"`
+		UserID string `db:"user_id"`
+		Track  []byte `db:"track"`
+	}
+	if err := tx.SelectContext(ctx, &rows, `
+		SELECT key, user_id, track
+		FROM trackindex_tracks
+	`); err != nil {
+		return 0, fmt.Errorf("querying tracks to rekey: %w", err)
+	}
+
+	rekeyed := 0
+	for _, row := range rows {
+		var track spotify.SimpleTrack
+		if err := json.Unmarshal(row.Track, &track); err != nil {
+			return 0, fmt.Errorf("unmarshalling track: %w", err)
+		}
+		key := t.trackIDFunc(track)
+		if key == row.Key {
+			continue
+		}
+
+		values := map[string]any{"old_key": row.Key, "new_key": key, "user_id": row.UserID}
+		if _, err := tx.NamedExecContext(ctx, `
+			UPDATE OR REPLACE trackindex_tracks
+			SET key = :new_key, updated_at = datetime('now')
+			WHERE key = :old_key AND user_id = :user_id
+		`, values); err != nil {
+			return 0, fmt.Errorf("rekeying track %s: %w", row.Key, err)
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+			UPDATE OR REPLACE trackindex_playlist_tracks
+			SET track_key = :new_key, updated_at = datetime('now')
+			WHERE track_key = :old_key AND user_id = :user_id
+		`, values); err != nil {
+			return 0, fmt.Errorf("rekeying playlist tracks of %s: %w", row.Key, err)
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+			UPDATE OR REPLACE trackindex_track_artists
+			SET track_key = :new_key
+			WHERE track_key = :old_key AND user_id = :user_id
+		`, values); err != nil {
+			return 0, fmt.Errorf("rekeying track artists of %s: %w", row.Key, err)
+		}
+		// Events of tracks which have since been removed from the index keep their old keys.
+		if _, err := tx.NamedExecContext(ctx, `
+			UPDATE trackindex_events
+			SET track_key = :new_key
+			WHERE track_key = :old_key AND user_id = :user_id
+		`, values); err != nil {
+			return 0, fmt.Errorf("rekeying events of %s: %w", row.Key, err)
+		}
+		// Blocked and recommended tracks stored without their tracks can only be
+		// rekeyed along with the indexed tracks.
+		if _, err := tx.NamedExecContext(ctx, `
+			UPDATE OR REPLACE user_blocklist
+			SET id = :new_key
+			WHERE type = :type AND id = :old_key AND user_id = :user_id AND track IS NULL
+		`, map[string]any{"old_key": row.Key, "new_key": key, "user_id": row.UserID, "type": recommendations.BlockTrack}); err != nil {
+			return 0, fmt.Errorf("rekeying blocklisted track %s: %w", row.Key, err)
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+			UPDATE OR REPLACE recommended_tracks
+			SET track_key = :new_key
+			WHERE track_key = :old_key AND user_id = :user_id AND track IS NULL
+		`, values); err != nil {
+			return 0, fmt.Errorf("rekeying recommendations of %s: %w", row.Key, err)
+		}
+		rekeyed++
+	}
+
+	if err := t.rekeyStoredTracks(ctx, tx, "user_blocklist", "id", "type = '"+recommendations.BlockTrack+"'"); err != nil {
+		return 0, fmt.Errorf("rekeying blocklist: %w", err)
+	}
+	if err := t.rekeyStoredTracks(ctx, tx, "recommended_tracks", "track_key", "1 = 1"); err != nil {
+		return 0, fmt.Errorf("rekeying recommended tracks: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO trackindex_keying (id, keyed_by)
+		VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			keyed_by = excluded.keyed_by,
+			updated_at = datetime('now')
+	`, keyedBy); err != nil {
+		return 0, fmt.Errorf("recording what the index is keyed by: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing tx: %w", err)
+	}
+	return rekeyed, nil
+}
+
+// rekeyStoredTracks recomputes the keys in keyColumn of the rows in table which
+// store their tracks, as blocked and recommended tracks needn't be in the index.
+func (t *TrackIndex) rekeyStoredTracks(ctx context.Context, tx *sqlx.Tx, table, keyColumn, where string) error {
+	var rows []struct {
+		RowID int64  `db:"rowid"`
+		Key   string `db:"key"`
+		Track []byte `db:"track"`
+	}
+	if err := tx.SelectContext(ctx, &rows, fmt.Sprintf(`
+		SELECT rowid, %s AS key, track
+		FROM %s
+		WHERE track IS NOT NULL AND %s
+	`, keyColumn, table, where)); err != nil {
+		return fmt.Errorf("querying tracks to rekey: %w", err)
+	}
+
+	for _, row := range rows {
+		var track spotify.SimpleTrack
+		if err := json.Unmarshal(row.Track, &track); err != nil {
+			return fmt.Errorf("unmarshalling track: %w", err)
+		}
+		key := t.trackIDFunc(track)
+		if key == row.Key {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE OR REPLACE %s
+			SET %s = ?
+			WHERE rowid = ?
+		`, table, keyColumn), key, row.RowID); err != nil {
+			return fmt.Errorf("rekeying track %s: %w", row.Key, err)
+		}
+	}
+	return nil
+}
+
+func trackISRC(track spotify.FullTrack) string {
+	return track.ExternalIDs["isrc"]
+}
+
+// artistNameSeparator separates the artist names of a track in the index. It's
+// the unit separator, which won't be searched for, so a search never matches
+// across two names.
+const artistNameSeparator = "\x1f"
+
+func trackArtistNames(track spotify.SimpleTrack) string {
+	names := make([]string, 0, len(track.Artists))
+	for _, artist := range track.Artists {
+		names = append(names, artist.Name)
+	}
+	return strings.Join(names, artistNameSeparator)
+}
+
+var trackSortColumns = map[string]string{
+	recommendations.TrackSortName:       "tt.name COLLATE NOCASE",
+	recommendations.TrackSortArtist:     "json_extract(tt.track, '$.artists[0].name') COLLATE NOCASE",
+	recommendations.TrackSortPopularity: "json_extract(tt.track, '$.popularity')",
+	recommendations.TrackSortAdded:      "MIN(tpt.inserted_at)",
+}
+
+// trackSearchFrom joins the tracks with their playlists and filters them by a
+// TrackSearch, it's shared by the search and the count of its matches.
+const trackSearchFrom = `
+	FROM trackindex_tracks AS tt
+	INNER JOIN
+		trackindex_playlist_tracks AS tpt
+		ON tpt.track_key = tt.key
+			AND tpt.user_id = tt.user_id
+	INNER JOIN
+		trackindex_playlists AS tp
+		ON tp.id = tpt.playlist_id
+			AND tp.user_id = tpt.user_id
+	WHERE
+		tt.user_id = :user_id
+		AND (
+			:query = ''
+			OR tt.name LIKE :query_pattern ESCAPE '\'
+			OR tt.artist_names LIKE :query_pattern ESCAPE '\'
+		)
+		AND (
+			:artist = ''
+			OR tt.artist_names LIKE :artist_pattern ESCAPE '\'
+		)
+		AND (
+			:playlist = ''
+			OR EXISTS (
+				SELECT 1
+				FROM trackindex_playlist_tracks AS ftpt
+				INNER JOIN
+					trackindex_playlists AS ftp
+					ON ftp.id = ftpt.playlist_id
+						AND ftp.user_id = ftpt.user_id
+				WHERE
+					ftpt.track_key = tt.key
+					AND ftpt.user_id = tt.user_id
+					AND (ftp.id = :playlist OR ftp.name LIKE :playlist_pattern ESCAPE '\')
+			)
+		)
+`
+
+// Search finds tracks by case insensitive substring matches on track, artist and
+// playlist names. Substrings can't be looked up in an index, so it scans an index
+// of the user's track and artist names, which is fast enough for libraries of
+// tens of thousands of tracks. A full text index would avoid the scan, but the
+// FTS5 module is only in builds of go-sqlite3 with the sqlite_fts5 tag and it
+// matches whole words rather than substrings, unless its trigram tokenizer is
+// used, which can't match queries shorter than three characters.
+func (t *TrackIndex) Search(ctx context.Context, userID string, search recommendations.TrackSearch) (recommendations.TrackSearchResult, error) {
+	sortColumn, ok := trackSortColumns[search.Sort]
+	if !ok {
+		return recommendations.TrackSearchResult{}, fmt.Errorf("unknown sort field %q", search.Sort)
+	}
+	direction := "ASC"
+	if search.Descending {
+		direction = "DESC"
+	}
+
+	db, release := t.db.RGet(ctx)
+	defer release()
+
+	params := map[string]any{
+		"user_id":          userID,
+		"query":            search.Query,
+		"query_pattern":    likePattern(search.Query),
+		"artist":           search.Artist,
+		"artist_pattern":   likePattern(search.Artist),
+		"playlist":         search.Playlist,
+		"playlist_pattern": likePattern(search.Playlist),
+		"limit":            search.Limit,
+		"offset":           search.Offset,
+	}
+
+	result := recommendations.TrackSearchResult{
+		Tracks: make([]recommendations.IndexedTrack, 0),
+		Limit:  search.Limit,
+		Offset: search.Offset,
+	}
+
+	// The total is counted separately as pages past the last match have no rows to
+	// count it on.
+	countQuery, countArgs, err := sqlx.Named(`
+		SELECT COUNT(DISTINCT tt.key)
+		`+trackSearchFrom, params)
+	if err != nil {
+		return recommendations.TrackSearchResult{}, fmt.Errorf("building track index count query: %w", err)
+	}
+	if err := db.GetContext(ctx, &result.Total, db.Rebind(countQuery), countArgs...); err != nil {
+		return recommendations.TrackSearchResult{}, fmt.Errorf("counting track index matches: %w", err)
+	}
+
+	rows, err := db.NamedQueryContext(ctx, `
+		SELECT
+			tt.key,
+			tt.track,
+			json_group_array(json(tp.simple_playlist)) AS playlists
+		`+trackSearchFrom+`
+		GROUP BY tt.key
+		ORDER BY `+sortColumn+` `+direction+`, tt.key
+		LIMIT :limit OFFSET :offset
+	`, params)
+	if err != nil {
+		return recommendations.TrackSearchResult{}, fmt.Errorf("searching track index: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key                  string
+			trackJSON, playlists []byte
+		)
+		if err := rows.Scan(&key, &trackJSON, &playlists); err != nil {
+			return recommendations.TrackSearchResult{}, fmt.Errorf("scanning track index: %w", err)
+		}
+
+		track := recommendations.IndexedTrack{Key: key}
+		if err := json.Unmarshal(trackJSON, &track.Track); err != nil {
+			return recommendations.TrackSearchResult{}, fmt.Errorf("unmarshalling track: %w", err)
+		}
+		if err := json.Unmarshal(playlists, &track.Playlists); err != nil {
+			return recommendations.TrackSearchResult{}, fmt.Errorf("unmarshalling playlists: %w", err)
+		}
+		result.Tracks = append(result.Tracks, track)
+	}
+	if err := rows.Err(); err != nil {
+		return recommendations.TrackSearchResult{}, fmt.Errorf("iterating track index: %w", err)
+	}
+
+	return result, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func likePattern(v string) string {
+	return "%" + likeEscaper.Replace(v) + "%"
+}
+
+func (t *TrackIndex) Duplicates(ctx context.Context, userID string, nearKey func(spotify.SimpleTrack) string) ([]recommendations.DuplicateTrack, error) {
+	db, release := t.db.RGet(ctx)
+	defer release()
+
+	// Near duplicates need every track, otherwise only the ones on several playlists are relevant.
+	minPlaylists := 2
+	if nearKey != nil {
+		minPlaylists = 1
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			tt.key,
+			tt.track,
+			json_group_array(json(tp.simple_playlist)) AS playlists
+		FROM trackindex_tracks AS tt
+		INNER JOIN
+			trackindex_playlist_tracks AS tpt
+			ON tpt.track_key = tt.key
+				AND tpt.user_id = tt.user_id
+		INNER JOIN
+			trackindex_playlists AS tp
+			ON tp.id = tpt.playlist_id
+				AND tp.user_id = tpt.user_id
+		WHERE tt.user_id = ?
+		GROUP BY tt.key
+		HAVING COUNT(*) >= ?
+		ORDER BY tt.key
+	`, userID, minPlaylists)
+	if err != nil {
+		return nil, fmt.Errorf("querying track index for duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*recommendations.DuplicateTrack)
+	groupKeys := make([]string, 0)
+	for rows.Next() {
+		var (
+			key                  string
+			trackJSON, playlists []byte
+		)
+		if err := rows.Scan(&key, &trackJSON, &playlists); err != nil {
+			return nil, fmt.Errorf("scanning track index: %w", err)
+		}
+
+		var track spotify.FullTrack
+		if err := json.Unmarshal(trackJSON, &track); err != nil {
+			return nil, fmt.Errorf("unmarshalling track: %w", err)
+		}
+		var trackPlaylists []spotify.SimplePlaylist
+		if err := json.Unmarshal(playlists, &trackPlaylists); err != nil {
+			return nil, fmt.Errorf("unmarshalling playlists: %w", err)
+		}
+
+		groupKey := key
+		if nearKey != nil {
+			groupKey = nearKey(track.SimpleTrack)
+		}
+		group, exists := groups[groupKey]
+		if !exists {
+			group = &recommendations.DuplicateTrack{}
+			groups[groupKey] = group
+			groupKeys = append(groupKeys, groupKey)
+		}
+		group.Keys = append(group.Keys, key)
+		group.Tracks = append(group.Tracks, track)
+		for _, p := range trackPlaylists {
+			if !containsPlaylist(group.Playlists, p.ID) {
+				group.Playlists = append(group.Playlists, p)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating track index: %w", err)
+	}
+
+	duplicates := make([]recommendations.DuplicateTrack, 0)
+	for _, groupKey := range groupKeys {
+		group := groups[groupKey]
+		group.Near = len(group.Keys) > 1
+		// A single track on a single playlist isn't a duplicate, but a track and its
+		// near duplicate on the same playlist is.
+		if !group.Near && len(group.Playlists) < 2 {
+			continue
+		}
+		duplicates = append(duplicates, *group)
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return len(duplicates[i].Playlists) > len(duplicates[j].Playlists)
+	})
+
+	return duplicates, nil
+}
+
+func containsPlaylist(playlists []spotify.SimplePlaylist, id spotify.ID) bool {
+	for _, p := range playlists {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+type trackEventRow struct {
+	Type         string `db:"type"`
+	PlaylistID   string `db:"playlist_id"`
+	PlaylistName string `db:"playlist_name"`
+	TrackKey     string `db:"track_key"`
+	TrackName    string `db:"track_name"`
+	OccurredAt   string `db:"occurred_at"`
+}
+
+func (r trackEventRow) toTrackEvent() (recommendations.TrackEvent, error) {
+	occurredAt, err := parseTime(r.OccurredAt)
+	if err != nil {
+		return recommendations.TrackEvent{}, fmt.Errorf("parsing occurred_at: %w", err)
+	}
+	return recommendations.TrackEvent{
+		Type:         r.Type,
+		PlaylistID:   r.PlaylistID,
+		PlaylistName: r.PlaylistName,
+		TrackKey:     r.TrackKey,
+		TrackName:    r.TrackName,
+		OccurredAt:   occurredAt,
 	}, nil
 }
+
+func toTrackEvents(rows []trackEventRow) ([]recommendations.TrackEvent, error) {
+	events := make([]recommendations.TrackEvent, 0, len(rows))
+	for _, row := range rows {
+		event, err := row.toTrackEvent()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (t *TrackIndex) History(ctx context.Context, userID string, query recommendations.HistoryQuery) ([]recommendations.TrackEvent, error) {
+	db, release := t.db.RGet(ctx)
+	defer release()
+
+	stmt, args, err := db.BindNamed(`
+		SELECT type, playlist_id, playlist_name, track_key, track_name, occurred_at
+		FROM trackindex_events
+		WHERE
+			user_id = :user_id
+			AND (
+				:playlist = ''
+				OR playlist_id = :playlist
+				OR playlist_name LIKE :playlist_pattern ESCAPE '\'
+			)
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT :limit OFFSET :offset
+	`, map[string]any{
+		"user_id":          userID,
+		"playlist":         query.Playlist,
+		"playlist_pattern": likePattern(query.Playlist),
+		"limit":            query.Limit,
+		"offset":           query.Offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("binding history query: %w", err)
+	}
+
+	var rows []trackEventRow
+	if err := db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, fmt.Errorf("querying track index history: %w", err)
+	}
+	return toTrackEvents(rows)
+}
+
+func (t *TrackIndex) TrackHistory(ctx context.Context, userID string, track spotify.FullTrack) ([]recommendations.TrackEvent, error) {
+	db, release := t.db.RGet(ctx)
+	defer release()
+
+	stmt, args, err := db.BindNamed(`
+		SELECT type, playlist_id, playlist_name, track_key, track_name, occurred_at
+		FROM trackindex_events
+		WHERE
+			user_id = :user_id
+			AND (
+				track_key = :track_key
+				OR track_key IN (
+					SELECT key
+					FROM trackindex_tracks
+					WHERE user_id = :user_id AND isrc = :isrc
+				)
+			)
+		ORDER BY occurred_at, id
+	`, map[string]any{
+		"user_id":   userID,
+		"track_key": t.trackIDFunc(track.SimpleTrack),
+		"isrc":      trackISRC(track),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("binding track history query: %w", err)
+	}
+
+	var rows []trackEventRow
+	if err := db.SelectContext(ctx, &rows, stmt, args...); err != nil {
+		return nil, fmt.Errorf("querying history of track %s (%s): %w", track.Name, track.ID, err)
+	}
+	return toTrackEvents(rows)
+}
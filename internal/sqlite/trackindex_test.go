@@ -0,0 +1,242 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+	"github.com/kristofferostlund/recommendli/internal/recommendations/spotifytest"
+	"github.com/zmb3/spotify"
+)
+
+const testUserID = "user"
+
+func testPlaylist(id, snapshotID, name string, tracks ...spotify.FullTrack) spotify.FullPlaylist {
+	playlist := spotify.FullPlaylist{
+		SimplePlaylist: spotify.SimplePlaylist{
+			ID:         spotify.ID(id),
+			Name:       name,
+			SnapshotID: snapshotID,
+			Owner:      spotify.User{ID: testUserID},
+		},
+	}
+	for _, track := range tracks {
+		playlist.Tracks.Tracks = append(playlist.Tracks.Tracks, spotify.PlaylistTrack{Track: track})
+	}
+	playlist.Tracks.Total = len(tracks)
+	playlist.SimplePlaylist.Tracks.Total = uint(len(tracks))
+	return playlist
+}
+
+func TestTrackIndexSyncBackfillsExistingTracks(t *testing.T) {
+	ctx := context.Background()
+	idx := NewTrackIndex(newTestDB(t), recommendations.NewTrackMatcher(recommendations.MatchExact).Key)
+
+	_, withoutISRC := spotifytest.NewAlbum("album-1", "Album").By(spotifytest.Artist("artist-1", "Artist")).
+		Track("track-1", "Song").
+		Track("track-2", "Other Song").
+		Build()
+	if _, err := idx.Sync(ctx, testUserID, []spotify.FullPlaylist{testPlaylist("playlist-1", "1", "Metal 1", withoutISRC[0])}, nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	_, withISRC := spotifytest.NewAlbum("album-1", "Album").By(spotifytest.Artist("artist-1", "Artist")).
+		Track("track-1", "Song", spotifytest.ISRC("SE0000000001")).
+		Build()
+	changed := testPlaylist("playlist-1", "2", "Metal 1", withISRC[0], withoutISRC[1])
+	stats, err := idx.Sync(ctx, testUserID, nil, []spotify.FullPlaylist{changed}, nil)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if stats.PlaylistTracksAdded != 1 {
+		t.Errorf("added %d playlist tracks, want 1", stats.PlaylistTracksAdded)
+	}
+
+	// A re-release is only matched by the ISRC, which the track already on the
+	// playlist got when it was synced again.
+	_, reRelease := spotifytest.NewAlbum("album-2", "Album (Remastered)").By(spotifytest.Artist("artist-1", "Artist")).
+		Track("track-3", "Song - Remastered", spotifytest.ISRC("SE0000000001")).
+		Build()
+	playlists, err := idx.Lookup(ctx, testUserID, reRelease[0])
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if len(playlists) != 1 || playlists[0].ID != "playlist-1" {
+		t.Errorf("Lookup() = %v, want playlist-1", playlists)
+	}
+}
+
+func TestTrackIndexSyncOnlyWritesChangedTracks(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	idx := NewTrackIndex(db, recommendations.NewTrackMatcher(recommendations.MatchExact).Key)
+
+	_, tracks := spotifytest.NewAlbum("album-1", "Album").By(spotifytest.Artist("artist-1", "Artist")).
+		Track("track-1", "One", spotifytest.ISRC("SE0000000001")).
+		Track("track-2", "Two").
+		Track("track-3", "Three").
+		Track("track-4", "Four").
+		Build()
+	if _, err := idx.Sync(ctx, testUserID, []spotify.FullPlaylist{testPlaylist("playlist-1", "1", "Metal 1", tracks[:3]...)}, nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Marks the stored tracks, so that writing them again is noticed.
+	raw, release := db.Get(ctx)
+	raw.MustExec(`UPDATE trackindex_tracks SET name = 'unchanged'`)
+	release()
+
+	changed := testPlaylist("playlist-1", "2", "Metal 1", tracks[0], tracks[3])
+	stats, err := idx.Sync(ctx, testUserID, nil, []spotify.FullPlaylist{changed}, nil)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if stats.PlaylistTracksAdded != 1 || stats.PlaylistTracksRemoved != 2 {
+		t.Errorf("added %d and removed %d playlist tracks, want 1 and 2", stats.PlaylistTracksAdded, stats.PlaylistTracksRemoved)
+	}
+
+	var names []string
+	raw, release = db.RGet(ctx)
+	err = raw.SelectContext(ctx, &names, `SELECT name FROM trackindex_tracks ORDER BY name`)
+	release()
+	if err != nil {
+		t.Fatalf("querying tracks: %v", err)
+	}
+	if fmt.Sprint(names) != fmt.Sprint([]string{"Four", "unchanged"}) {
+		t.Errorf("indexed track names = %v, want the unchanged track left alone and the added one written", names)
+	}
+
+	events, err := idx.History(ctx, testUserID, recommendations.HistoryQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	removed := make([]string, 0)
+	for _, e := range events {
+		if e.Type == recommendations.TrackEventRemoved {
+			removed = append(removed, e.TrackKey)
+		}
+	}
+	if len(removed) != 2 {
+		t.Errorf("recorded removal of %v, want the two removed tracks", removed)
+	}
+}
+
+func TestTrackIndexSearchTotal(t *testing.T) {
+	ctx := context.Background()
+	idx := NewTrackIndex(newTestDB(t), recommendations.NewTrackMatcher(recommendations.MatchExact).Key)
+
+	_, tracks := spotifytest.NewAlbum("album-1", "Album").By(spotifytest.Artist("artist-1", "Artist")).
+		Track("track-1", "One").
+		Track("track-2", "Two").
+		Track("track-3", "Three").
+		Build()
+	_, featured := spotifytest.NewAlbum("album-2", "Split").By(spotifytest.Artist("artist-2", "Band"), spotifytest.Artist("artist-3", "Singer")).
+		Track("track-4", "Duet").
+		Build()
+	playlists := []spotify.FullPlaylist{
+		testPlaylist("playlist-1", "1", "Metal 1", tracks...),
+		testPlaylist("playlist-2", "1", "Duets", featured...),
+	}
+	if _, err := idx.Sync(ctx, testUserID, playlists, nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		search     recommendations.TrackSearch
+		wantTracks int
+		wantTotal  int
+	}{
+		{name: "first page", search: recommendations.TrackSearch{Limit: 2}, wantTracks: 2, wantTotal: 4},
+		{name: "last page", search: recommendations.TrackSearch{Limit: 2, Offset: 2}, wantTracks: 2, wantTotal: 4},
+		{name: "past the last page", search: recommendations.TrackSearch{Limit: 2, Offset: 10}, wantTracks: 0, wantTotal: 4},
+		{name: "query", search: recommendations.TrackSearch{Query: "t", Limit: 10}, wantTracks: 4, wantTotal: 4},
+		{name: "query by artist", search: recommendations.TrackSearch{Query: "artist", Limit: 10}, wantTracks: 3, wantTotal: 3},
+		{name: "query by second artist", search: recommendations.TrackSearch{Query: "SING", Limit: 10}, wantTracks: 1, wantTotal: 1},
+		{name: "artist", search: recommendations.TrackSearch{Artist: "band", Limit: 10}, wantTracks: 1, wantTotal: 1},
+		{name: "artist across names", search: recommendations.TrackSearch{Artist: "bandsinger", Limit: 10}, wantTracks: 0, wantTotal: 0},
+		{name: "playlist", search: recommendations.TrackSearch{Playlist: "duet", Limit: 10}, wantTracks: 1, wantTotal: 1},
+		{name: "no matches", search: recommendations.TrackSearch{Query: "four", Limit: 10}, wantTracks: 0, wantTotal: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.search.Sort = recommendations.TrackSortName
+			result, err := idx.Search(ctx, testUserID, tt.search)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(result.Tracks) != tt.wantTracks || result.Total != tt.wantTotal {
+				t.Errorf("Search() got %d tracks of %d, want %d of %d", len(result.Tracks), result.Total, tt.wantTracks, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestTrackIndexRekey(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	exact := recommendations.NewTrackMatcher(recommendations.MatchExact)
+	loose := recommendations.NewTrackMatcher(recommendations.MatchLoose)
+
+	_, indexed := spotifytest.NewAlbum("album-1", "Album").By(spotifytest.Artist("artist-1", "Artist")).
+		Track("track-1", "Song (Remastered)").
+		Build()
+	_, discovered := spotifytest.NewAlbum("album-2", "Live").By(spotifytest.Artist("artist-2", "Band")).
+		Track("track-2", "Other Song - Live").
+		Build()
+
+	idx := NewTrackIndex(db, exact.Key)
+	if n, err := idx.Rekey(ctx, string(recommendations.MatchExact)); err != nil || n != 0 {
+		t.Fatalf("Rekey() of an empty index = %d, %v", n, err)
+	}
+	if _, err := idx.Sync(ctx, testUserID, []spotify.FullPlaylist{testPlaylist("playlist-1", "1", "Metal 1", indexed...)}, nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	blocklist := NewBlocklistStore(db)
+	if err := blocklist.Add(ctx, testUserID, recommendations.BlocklistEntry{
+		Type:      recommendations.BlockTrack,
+		ID:        exact.Key(discovered[0].SimpleTrack),
+		Track:     &discovered[0].SimpleTrack,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("adding to blocklist: %v", err)
+	}
+	recommended := NewRecommendationStore(db)
+	if err := recommended.Record(ctx, testUserID, "recommendli", time.Now(), []recommendations.RecommendedTrack{
+		{Key: exact.Key(discovered[0].SimpleTrack), TrackID: discovered[0].ID.String(), Track: discovered[0].SimpleTrack},
+	}); err != nil {
+		t.Fatalf("recording recommendations: %v", err)
+	}
+
+	idx = NewTrackIndex(db, loose.Key)
+	n, err := idx.Rekey(ctx, string(recommendations.MatchLoose))
+	if err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Rekey() rekeyed %d tracks, want 1", n)
+	}
+	if n, err := idx.Rekey(ctx, string(recommendations.MatchLoose)); err != nil || n != 0 {
+		t.Errorf("Rekey() with the same strictness = %d, %v, want nothing rekeyed", n, err)
+	}
+
+	if has, err := idx.Has(ctx, testUserID, indexed[0]); err != nil || !has {
+		t.Errorf("Has() of the rekeyed track = %t, %v", has, err)
+	}
+	entries, err := blocklist.List(ctx, testUserID)
+	if err != nil {
+		t.Fatalf("listing blocklist: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != loose.Key(discovered[0].SimpleTrack) {
+		t.Errorf("blocklist = %v, want the track keyed %q", entries, loose.Key(discovered[0].SimpleTrack))
+	}
+	previous, err := recommended.Previous(ctx, testUserID, []string{loose.Key(discovered[0].SimpleTrack)}, "")
+	if err != nil {
+		t.Fatalf("getting previous recommendations: %v", err)
+	}
+	if len(previous) != 1 {
+		t.Errorf("previous recommendations = %v, want the track keyed %q", previous, loose.Key(discovered[0].SimpleTrack))
+	}
+}
@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kristofferostlund/recommendli/internal/recommendations"
+)
+
+var _ recommendations.UserPreferenceProvider = (*UserPreferenceStore)(nil)
+
+type UserPreferenceStore struct {
+	db       *DB
+	defaults recommendations.UserPreferences
+}
+
+func NewUserPreferenceStore(db *DB, defaults recommendations.UserPreferences) *UserPreferenceStore {
+	return &UserPreferenceStore{
+		db:       db,
+		defaults: defaults,
+	}
+}
+
+func (s *UserPreferenceStore) GetPreferences(ctx context.Context, userID string) (recommendations.UserPreferences, error) {
+	db, release := s.db.RGet(ctx)
+	defer release()
+
+	var b []byte
+	if err := db.GetContext(ctx, &b, `
+		SELECT preferences
+		FROM user_preferences
+		WHERE user_id = ?
+	`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.defaults, nil
+		}
+		return recommendations.UserPreferences{}, fmt.Errorf("querying user preferences for user %s: %w", userID, err)
+	}
+
+	// Stored preferences are unmarshalled on top of the defaults so any
+	// preference the user hasn't set yet keeps its default value.
+	prefs := s.defaults
+	if err := json.Unmarshal(b, &prefs); err != nil {
+		return recommendations.UserPreferences{}, fmt.Errorf("unmarshalling user preferences for user %s: %w", userID, err)
+	}
+
+	return prefs, nil
+}
+
+func (s *UserPreferenceStore) SetPreferences(ctx context.Context, userID string, prefs recommendations.UserPreferences) error {
+	db, release := s.db.Get(ctx)
+	defer release()
+
+	b, err := json.Marshal(prefs)
+	if err != nil {
+		return fmt.Errorf("marshalling user preferences: %w", err)
+	}
+
+	if _, err := db.NamedExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, preferences, updated_at)
+		VALUES (:user_id, :preferences, datetime('now'))
+		ON CONFLICT (user_id) DO UPDATE
+		SET preferences = excluded.preferences,
+			updated_at = excluded.updated_at
+	`, map[string]any{"user_id": userID, "preferences": b}); err != nil {
+		return fmt.Errorf("storing user preferences for user %s: %w", userID, err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"syscall"
 	"time"
 
@@ -19,20 +21,54 @@ import (
 	"github.com/kristofferostlund/recommendli/internal/recommendations"
 	"github.com/kristofferostlund/recommendli/internal/sqlite"
 	"github.com/kristofferostlund/recommendli/pkg/migrations"
+	"github.com/kristofferostlund/recommendli/pkg/secretbox"
 	"github.com/kristofferostlund/recommendli/pkg/singleflight"
 	"github.com/kristofferostlund/recommendli/pkg/slogutil"
 	"github.com/kristofferostlund/recommendli/pkg/srv"
+	"github.com/kristofferostlund/recommendli/pkg/transport"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Config struct {
-	SpotifyClientID     string `envconfig:"SPOTIFY_ID"`
-	SpotifyClientSecret string `envconfig:"SPOTIFY_SECRET"`
-	SpotifyRedirectHost string `envconfig:"SPOTIFY_REDIRECT_HOST" default:"http://127.0.0.1:9999"`
-	LogLevel            string `envconfig:"LOG_LEVEL" default:"info"`
-	Addr                string `envconfig:"ADDR" default:"0.0.0.0:9999"`
-	FileCacheBaseDir    string `envconfig:"FILE_CACHE_BASE_DIR" default:"/tmp/recommendli"`
-	SQLiteDBPath        string `envconfig:"SQLITE_DB_PATH" default:"/tmp/recommendli.sqlite"`
+	SpotifyClientID     string        `envconfig:"SPOTIFY_ID"`
+	SpotifyClientSecret string        `envconfig:"SPOTIFY_SECRET"`
+	SpotifyRedirectHost string        `envconfig:"SPOTIFY_REDIRECT_HOST" default:"http://127.0.0.1:9999"`
+	LogLevel            string        `envconfig:"LOG_LEVEL" default:"info"`
+	Addr                string        `envconfig:"ADDR" default:"0.0.0.0:9999"`
+	FileCacheBaseDir    string        `envconfig:"FILE_CACHE_BASE_DIR" default:"/tmp/recommendli"`
+	SQLiteDBPath        string        `envconfig:"SQLITE_DB_PATH" default:"/tmp/recommendli.sqlite"`
+	SchedulerInterval   time.Duration `envconfig:"SCHEDULER_INTERVAL" default:"1m"`
+	// RequestTimeout is how long requests may take, other than streamed events and
+	// synchronously generated discovery playlists. The UI generates them as discovery
+	// jobs, but the built UI in static/dist predates that until it's rebuilt.
+	RequestTimeout time.Duration `envconfig:"REQUEST_TIMEOUT" default:"5m"`
+	// InstanceID identifies the discovery jobs run by this instance, it has to stay
+	// the same across restarts. It defaults to the hostname.
+	InstanceID string `envconfig:"INSTANCE_ID"`
+	// TrackMatchStrictness is one of exact, normalized or loose. The track index is
+	// rekeyed on startup when it changes.
+	TrackMatchStrictness string `envconfig:"TRACK_MATCH_STRICTNESS" default:"exact"`
+	// TokenEncryptionKey is a base64 encoded 32 byte key, e.g. from `openssl rand -base64 32`.
+	TokenEncryptionKey string `envconfig:"TOKEN_ENCRYPTION_KEY" required:"true"`
+	// CookieEncryptionKey encrypts new cookies, while cookies encrypted with any of
+	// CookieDecryptionKeys are still accepted. Keys are rotated by moving the current
+	// key to CookieDecryptionKeys and setting a new CookieEncryptionKey.
+	CookieEncryptionKey  string   `envconfig:"COOKIE_ENCRYPTION_KEY" required:"true"`
+	CookieDecryptionKeys []string `envconfig:"COOKIE_DECRYPTION_KEYS"`
+	// SpotifyAPIURL and SpotifyAccountsURL point the server at another Spotify, such
+	// as the mock in cmd/spotifymock. They default to the real Spotify.
+	SpotifyAPIURL      string `envconfig:"SPOTIFY_API_URL"`
+	SpotifyAccountsURL string `envconfig:"SPOTIFY_ACCOUNTS_URL"`
+	// SpotifyCassetteMode is record to write every request to Spotify and its
+	// response to SpotifyCassetteDir, or replay to respond with them instead of
+	// talking to Spotify. Credentials are scrubbed from the recordings, and they're
+	// only replayed with the SpotifyAPIURL and SpotifyAccountsURL they were recorded with.
+	SpotifyCassetteMode string `envconfig:"SPOTIFY_CASSETTE_MODE"`
+	SpotifyCassetteDir  string `envconfig:"SPOTIFY_CASSETTE_DIR" default:"/tmp/recommendli/cassettes"`
+	// SpotifyRequestsPerSecond limits the requests to Spotify per user, with bursts of
+	// up to SpotifyRequestBurst requests. Zero disables the limit.
+	SpotifyRequestsPerSecond float64 `envconfig:"SPOTIFY_REQUESTS_PER_SECOND" default:"10"`
+	SpotifyRequestBurst      int     `envconfig:"SPOTIFY_REQUEST_BURST" default:"10"`
 }
 
 var migrationsDir = fmt.Sprintf("file://%s", absolutePathTo("./migrations"))
@@ -56,6 +92,21 @@ func main() {
 	defer sqliteDB.Close()
 	db := sqlite.Wrap(sqliteDB)
 
+	tokenKey, err := secretbox.ParseKey(cfg.TokenEncryptionKey)
+	if err != nil {
+		slogutil.Fatal("Could not parse token encryption key", slogutil.Error(err))
+	}
+	tokenBox, err := secretbox.New(tokenKey)
+	if err != nil {
+		slogutil.Fatal("Could not set up token encryption", slogutil.Error(err))
+	}
+	tokens := sqlite.NewTokenStore(db, tokenBox)
+
+	cookieKeys, err := parseCookieKeys(cfg.CookieEncryptionKey, cfg.CookieDecryptionKeys)
+	if err != nil {
+		slogutil.Fatal("Could not set up cookie encryption", slogutil.Error(err))
+	}
+
 	spotifyRedirectURLstr := fmt.Sprintf("%s/recommendations/v1/spotify/auth/callback", cfg.SpotifyRedirectHost)
 	redirectURL, err := url.Parse(spotifyRedirectURLstr)
 	if err != nil {
@@ -73,21 +124,82 @@ func main() {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(50 * time.Minute)) // haha wow.
+	r.Use(timeoutExcept(cfg.RequestTimeout, "/recommendations/v1/events", "/recommendations/v1/generate-discovery-playlist"))
 
 	r.Get("/status", getStatus())
 	r.Method(http.MethodGet, "/metrics", promhttp.Handler())
 
-	authAdaptor := recommendations.NewSpotifyAuthAdaptor(cfg.SpotifyClientID, cfg.SpotifyClientSecret, *redirectURL, *uiRedirectURL)
+	spotifyEndpoint, err := parseSpotifyEndpoint(cfg.SpotifyAPIURL, cfg.SpotifyAccountsURL)
+	if err != nil {
+		slogutil.Fatal("Could not parse Spotify endpoint", slogutil.Error(err))
+	}
+	if spotifyEndpoint != recommendations.DefaultSpotifyEndpoint() {
+		slog.Warn("Using custom Spotify endpoint", slog.String("api_url", spotifyEndpoint.APIURL.String()), slog.String("accounts_url", spotifyEndpoint.AccountsURL.String()))
+	}
+
+	spotifyTransport, err := newSpotifyTransport(cfg.SpotifyCassetteMode, cfg.SpotifyCassetteDir)
+	if err != nil {
+		slogutil.Fatal("Could not set up Spotify cassettes", slogutil.Error(err))
+	}
+	if cfg.SpotifyCassetteMode != "" {
+		slog.Warn("Using Spotify cassettes", slog.String("mode", cfg.SpotifyCassetteMode), slog.String("dir", cfg.SpotifyCassetteDir))
+	}
+
+	var spotifyRateLimiter *transport.RateLimiter
+	if cfg.SpotifyRequestsPerSecond > 0 {
+		spotifyRateLimiter = recommendations.NewSpotifyRateLimiter(cfg.SpotifyRequestsPerSecond, cfg.SpotifyRequestBurst)
+	}
+
+	sfLocker := sqlite.NewLocker(db)
+	authAdaptor := recommendations.NewSpotifyAuthAdaptor(cfg.SpotifyClientID, cfg.SpotifyClientSecret, *redirectURL, *uiRedirectURL, sqlite.NewSessionStore(db), tokens, cookieKeys, spotifyEndpoint, spotifyTransport, spotifyRateLimiter, sfLocker)
 	r.Get(authAdaptor.Path(), authAdaptor.TokenCallbackHandler())
 	r.Get(authAdaptor.UIRedirectPath(), authAdaptor.UIRedirectHandler())
 
-	recommendatinsHandler, err := getRecommendationsHandler(authAdaptor, sqlitePeristenceFactory(db), sqlite.NewTrackIndex(db, recommendations.TrackKey), sqlite.NewLocker(db))
+	matchStrictness, err := recommendations.ParseMatchStrictness(cfg.TrackMatchStrictness)
+	if err != nil {
+		slogutil.Fatal("Could not parse track match strictness", slogutil.Error(err))
+	}
+	matcher := recommendations.NewTrackMatcher(matchStrictness)
+	trackIndex := sqlite.NewTrackIndex(db, matcher.Key)
+	if rekeyed, err := trackIndex.Rekey(context.Background(), string(matchStrictness)); err != nil {
+		slogutil.Fatal("Rekeying track index", slogutil.Error(err))
+	} else if rekeyed > 0 {
+		slog.Info("Rekeyed track index", slog.Int("count", rekeyed), slog.String("strictness", string(matchStrictness)))
+	}
+
+	instanceID := cfg.InstanceID
+	if instanceID == "" {
+		if instanceID, err = os.Hostname(); err != nil {
+			slogutil.Fatal("Could not get hostname for instance ID", slogutil.Error(err))
+		}
+	}
+	discoveryJobs := sqlite.NewDiscoveryJobStore(db, instanceID)
+	if failed, err := discoveryJobs.FailUnfinished(context.Background(), recommendations.JobStaleAfter, "interrupted by server restart"); err != nil {
+		slogutil.Fatal("Failing unfinished discovery jobs", slogutil.Error(err))
+	} else if failed > 0 {
+		slog.Warn("Failed discovery jobs interrupted by restart", slog.Int("count", failed))
+	}
+
+	recommendatinsHandler, scheduler, err := getRecommendationsHandler(cfg, authAdaptor, sqlitePeristenceFactory(db), recommendationStores{
+		userPreferences: sqlite.NewUserPreferenceStore(db, recommendations.DefaultUserPreferences()),
+		trackIndex:      trackIndex,
+		matcher:         matcher,
+		discoveryJobs:   discoveryJobs,
+		schedules:       sqlite.NewScheduleStore(db),
+		tokens:          tokens,
+		blocklist:       sqlite.NewBlocklistStore(db),
+		recommended:     sqlite.NewRecommendationStore(db),
+		sfLocker:        sfLocker,
+	})
 	if err != nil {
 		slogutil.Fatal("Setting up recommendations handler", slogutil.Error(err))
 	}
 	r.Mount("/recommendations", recommendatinsHandler)
 
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+	go scheduler.Run(schedulerCtx)
+
 	staticDir := "./static/dist"
 	if _, err := os.Stat(staticDir); os.IsNotExist(err) {
 		staticDir = "./static"
@@ -115,16 +227,94 @@ func main() {
 	slog.Info("Server shutdown")
 }
 
-func getRecommendationsHandler(authAdaptor *recommendations.AuthAdaptor, persistedKV kvPersistenceFactory, trackIndex recommendations.TrackIndex, sfLocker singleflight.Locker) (*chi.Mux, error) {
+// timeoutExcept times out requests after timeout, except for the requests to
+// untimedPaths, such as streams which stay open for as long as the client listens.
+func timeoutExcept(timeout time.Duration, untimedPaths ...string) func(http.Handler) http.Handler {
+	withTimeout := middleware.Timeout(timeout)
+	return func(next http.Handler) http.Handler {
+		timed := withTimeout(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(untimedPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+func parseSpotifyEndpoint(apiURL, accountsURL string) (recommendations.SpotifyEndpoint, error) {
+	endpoint := recommendations.DefaultSpotifyEndpoint()
+	if apiURL != "" {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			return recommendations.SpotifyEndpoint{}, fmt.Errorf("parsing api url: %w", err)
+		}
+		endpoint.APIURL = *u
+	}
+	if accountsURL != "" {
+		u, err := url.Parse(accountsURL)
+		if err != nil {
+			return recommendations.SpotifyEndpoint{}, fmt.Errorf("parsing accounts url: %w", err)
+		}
+		endpoint.AccountsURL = *u
+	}
+	return endpoint, nil
+}
+
+// newSpotifyTransport returns the transport requests to Spotify are sent through
+// for the cassette mode, where nil means http.DefaultTransport.
+func newSpotifyTransport(cassetteMode, cassetteDir string) (http.RoundTripper, error) {
+	switch cassetteMode {
+	case "":
+		return nil, nil
+	case "record":
+		return transport.Record(http.DefaultTransport, cassetteDir)
+	case "replay":
+		return transport.Replay(cassetteDir)
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q, must be one of record or replay", cassetteMode)
+	}
+}
+
+func parseCookieKeys(encryptKey string, decryptKeys []string) (*secretbox.Keyring, error) {
+	key, err := secretbox.ParseKey(encryptKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cookie encryption key: %w", err)
+	}
+	keys := make([][]byte, 0, len(decryptKeys))
+	for i, k := range decryptKeys {
+		key, err := secretbox.ParseKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cookie decryption key %d: %w", i, err)
+		}
+		keys = append(keys, key)
+	}
+	return secretbox.NewKeyring(key, keys...)
+}
+
+type recommendationStores struct {
+	userPreferences recommendations.UserPreferenceStore
+	trackIndex      recommendations.TrackIndex
+	matcher         *recommendations.TrackMatcher
+	discoveryJobs   recommendations.DiscoveryJobStore
+	schedules       recommendations.ScheduleStore
+	tokens          recommendations.TokenStore
+	blocklist       recommendations.BlocklistStore
+	recommended     recommendations.RecommendationStore
+	sfLocker        singleflight.Locker
+}
+
+func getRecommendationsHandler(cfg Config, authAdaptor *recommendations.AuthAdaptor, persistedKV kvPersistenceFactory, stores recommendationStores) (*chi.Mux, *recommendations.Scheduler, error) {
 	serviceCache := persistedKV("cache")
 	spotifyCache := persistedKV("spotify-provider")
 
-	recommendatinsHandler := recommendations.NewRouter(
-		recommendations.NewServiceFactory(serviceCache, recommendations.NewDummyUserPreferenceProvider(), trackIndex, sfLocker),
-		recommendations.NewSpotifyProviderFactory(spotifyCache),
-		authAdaptor,
-	)
-	return recommendatinsHandler, nil
+	svcFactory := recommendations.NewServiceFactory(serviceCache, stores.userPreferences, stores.trackIndex, stores.matcher, stores.discoveryJobs, stores.schedules, stores.tokens, stores.blocklist, stores.recommended, stores.sfLocker)
+	spotifyProviderFactory := recommendations.NewSpotifyProviderFactory(spotifyCache)
+
+	recommendatinsHandler := recommendations.NewRouter(svcFactory, spotifyProviderFactory, authAdaptor)
+	scheduler := recommendations.NewScheduler(svcFactory, spotifyProviderFactory, authAdaptor, stores.schedules, stores.tokens, stores.sfLocker, cfg.SchedulerInterval)
+	return recommendatinsHandler, scheduler, nil
 }
 
 type kvPersistenceFactory func(prefix string) recommendations.KeyValueStore
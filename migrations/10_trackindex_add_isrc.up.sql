@@ -0,0 +1,12 @@
+-- The ISRC is stable across re-releases of a track, unlike the Spotify ID, so it's
+-- checked before the key. The track column holds the full track, which is where the
+-- ISRC comes from.
+ALTER TABLE trackindex_tracks RENAME COLUMN simple_track TO track;
+ALTER TABLE trackindex_tracks ADD COLUMN isrc TEXT NULL;
+
+CREATE INDEX IF NOT EXISTS trackindex_tracks_isrc_idx ON trackindex_tracks (user_id, isrc);
+
+-- Clearing the snapshot IDs makes the next sync re-populate all playlists, which
+-- stores the full tracks and their ISRCs.
+UPDATE trackindex_playlists
+SET simple_playlist = json_set(simple_playlist, '$.snapshot_id', '');
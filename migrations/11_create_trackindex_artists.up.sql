@@ -0,0 +1,37 @@
+-- Artists are keyed by their Spotify ID, so different artists sharing a name are kept apart.
+CREATE TABLE IF NOT EXISTS trackindex_artists (
+  id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS trackindex_track_artists (
+  track_key TEXT NOT NULL,
+  artist_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (track_key, artist_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS trackindex_track_artists_artist_id_idx ON trackindex_track_artists (user_id, artist_id);
+
+INSERT OR IGNORE INTO trackindex_artists (id, user_id, name)
+SELECT
+  json_extract(artist.value, '$.id'),
+  trackindex_tracks.user_id,
+  json_extract(artist.value, '$.name')
+FROM trackindex_tracks
+CROSS JOIN json_each(trackindex_tracks.track, '$.artists') AS artist
+WHERE json_extract(artist.value, '$.id') <> '';
+
+INSERT OR IGNORE INTO trackindex_track_artists (track_key, artist_id, user_id)
+SELECT
+  trackindex_tracks.key,
+  json_extract(artist.value, '$.id'),
+  trackindex_tracks.user_id
+FROM trackindex_tracks
+CROSS JOIN json_each(trackindex_tracks.track, '$.artists') AS artist
+WHERE json_extract(artist.value, '$.id') <> '';
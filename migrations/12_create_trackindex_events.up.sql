@@ -0,0 +1,37 @@
+-- trackindex_events is append-only, tracks being added to and removed from playlists
+-- are recorded as they're noticed when syncing the index.
+CREATE TABLE IF NOT EXISTS trackindex_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id TEXT NOT NULL,
+  type TEXT NOT NULL,
+  playlist_id TEXT NOT NULL,
+  playlist_name TEXT NOT NULL,
+  track_key TEXT NOT NULL,
+  track_name TEXT NOT NULL,
+  occurred_at TEXT NOT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS trackindex_events_track_key_idx ON trackindex_events (user_id, track_key);
+CREATE INDEX IF NOT EXISTS trackindex_events_occurred_at_idx ON trackindex_events (user_id, occurred_at);
+
+-- Start out with the tracks already in the index, as of when they were indexed.
+INSERT INTO trackindex_events (user_id, type, playlist_id, playlist_name, track_key, track_name, occurred_at)
+SELECT
+  tpt.user_id,
+  'added',
+  tpt.playlist_id,
+  tp.name,
+  tpt.track_key,
+  tt.name,
+  tpt.inserted_at
+FROM trackindex_playlist_tracks AS tpt
+INNER JOIN
+  trackindex_playlists AS tp
+  ON tp.id = tpt.playlist_id
+    AND tp.user_id = tpt.user_id
+INNER JOIN
+  trackindex_tracks AS tt
+  ON tt.key = tpt.track_key
+    AND tt.user_id = tpt.user_id
+ORDER BY tpt.inserted_at;
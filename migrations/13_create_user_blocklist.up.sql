@@ -0,0 +1,10 @@
+-- user_blocklist holds the tracks, artists and albums a user never wants recommended.
+-- Tracks are stored by their track key, artists and albums by their Spotify IDs.
+CREATE TABLE IF NOT EXISTS user_blocklist (
+  user_id TEXT NOT NULL,
+  type TEXT NOT NULL,
+  id TEXT NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (user_id, type, id)
+);
@@ -0,0 +1,13 @@
+-- recommended_tracks records the tracks put on each recommendation playlist, so
+-- tracks can be kept from being recommended over and over again.
+CREATE TABLE IF NOT EXISTS recommended_tracks (
+  user_id TEXT NOT NULL,
+  playlist_name TEXT NOT NULL,
+  track_key TEXT NOT NULL,
+  track_id TEXT NOT NULL,
+  track_name TEXT NOT NULL,
+  recommended_at TEXT NOT NULL,
+  PRIMARY KEY (user_id, playlist_name, track_key)
+);
+
+CREATE INDEX IF NOT EXISTS recommended_tracks_track_key_idx ON recommended_tracks (user_id, track_key);
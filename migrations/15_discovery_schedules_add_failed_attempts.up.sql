@@ -0,0 +1,3 @@
+-- failed_attempts counts the runs which have failed in a row, failed runs are
+-- retried with a backoff rather than waiting for the next week.
+ALTER TABLE discovery_schedules ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0;
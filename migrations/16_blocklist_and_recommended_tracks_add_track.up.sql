@@ -0,0 +1,5 @@
+-- The tracks are stored along with their keys, so they can be rekeyed when the
+-- function tracks are keyed by changes. Blocked and recommended tracks are often
+-- not in the track index, which is what the rows stored before this fall back to.
+ALTER TABLE user_blocklist ADD COLUMN track JSONB NULL;
+ALTER TABLE recommended_tracks ADD COLUMN track JSONB NULL;
@@ -0,0 +1,8 @@
+-- trackindex_keying holds the single row recording what the track index was last
+-- keyed by, so it's only rekeyed when that changes. Indexes keyed before this was
+-- recorded are rekeyed once.
+CREATE TABLE IF NOT EXISTS trackindex_keying (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  keyed_by TEXT NOT NULL,
+  updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
@@ -0,0 +1,6 @@
+-- Jobs run in the instance which started them, which refreshes heartbeat_at while
+-- they run. On startup an instance only fails its own unfinished jobs and the
+-- ones which have stopped getting heartbeats, not the ones other instances run.
+-- Jobs from before this have no heartbeat and are considered stale.
+ALTER TABLE discovery_jobs ADD COLUMN instance_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE discovery_jobs ADD COLUMN heartbeat_at TEXT NULL;
@@ -0,0 +1,16 @@
+-- Searches match substrings of the track and artist names, which no index can
+-- seek. The artist names are kept next to the track name, separated by the unit
+-- separator, so that searches scan a covering index of the names rather than the
+-- tracks and the artists in their JSON.
+ALTER TABLE trackindex_tracks ADD COLUMN artist_names TEXT NOT NULL DEFAULT '';
+
+UPDATE trackindex_tracks
+SET artist_names = COALESCE((
+  SELECT group_concat(json_extract(artist.value, '$.name'), char(31))
+  FROM json_each(trackindex_tracks.track, '$.artists') AS artist
+), '');
+
+CREATE INDEX IF NOT EXISTS trackindex_tracks_names_idx ON trackindex_tracks (user_id, name, artist_names);
+
+-- The playlists of a track are looked up by its key when searching by playlist.
+CREATE INDEX IF NOT EXISTS trackindex_playlist_tracks_track_key_idx ON trackindex_playlist_tracks (user_id, track_key);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_preferences (
+  user_id TEXT NOT NULL,
+  -- preferences is the JSON encoding of recommendations.UserPreferences, fields missing
+  -- from it fall back to the defaults so new preferences don't require a migration.
+  preferences JSONB NOT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (user_id)
+);
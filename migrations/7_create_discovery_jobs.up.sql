@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS discovery_jobs (
+  id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+  state TEXT NOT NULL,
+  progress JSONB NOT NULL,
+  error TEXT NULL,
+  playlist JSONB NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS discovery_jobs_user_id_idx ON discovery_jobs (user_id);
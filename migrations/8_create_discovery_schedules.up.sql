@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS discovery_schedules (
+  user_id TEXT NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  weekday INTEGER NOT NULL,
+  hour INTEGER NOT NULL,
+  minute INTEGER NOT NULL,
+  timezone TEXT NOT NULL,
+  next_run_at TEXT NOT NULL,
+  last_run_at TEXT NULL,
+  last_error TEXT NULL,
+  last_playlist_id TEXT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (user_id)
+);
+
+CREATE INDEX IF NOT EXISTS discovery_schedules_next_run_at_idx ON discovery_schedules (enabled, next_run_at);
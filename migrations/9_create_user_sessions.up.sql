@@ -0,0 +1,18 @@
+-- Tokens are encrypted as they grant access to the users' Spotify accounts.
+CREATE TABLE IF NOT EXISTS user_tokens (
+  user_id TEXT NOT NULL,
+  token BLOB NOT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+  session_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  inserted_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (session_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON user_sessions (user_id);
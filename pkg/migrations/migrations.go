@@ -1,12 +1,13 @@
 package migrations
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
@@ -14,12 +15,29 @@ func Up(dir, dbConn string) error {
 	return up(dir, dbConn)
 }
 
+// UpSQLite runs the migrations on an open SQLite database, such as an in-memory
+// one which only lives for as long as it's open. The database is left open.
+func UpSQLite(dir string, db *sql.DB) error {
+	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("setting up migrations: %w", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance(dir, "sqlite3", driver)
+	if err != nil {
+		return fmt.Errorf("setting up migrations: %w", err)
+	}
+	return runUp(m)
+}
+
 func up(dir, dbConn string) error {
 	m, err := migrate.New(dir, dbConn)
 	if err != nil {
 		return fmt.Errorf("setting up migrations: %w", err)
 	}
+	return runUp(m)
+}
 
+func runUp(m *migrate.Migrate) error {
 	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return fmt.Errorf("running migrations: %w", err)
 	}
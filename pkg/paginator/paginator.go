@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
+	"math/rand"
+	"time"
 
 	"github.com/kristofferostlund/recommendli/pkg/ctxhelper"
+	"github.com/kristofferostlund/recommendli/pkg/slogutil"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -15,6 +19,7 @@ type Paginator struct {
 	initialOffset     int
 	initialTotalCount int
 	parallelism       int
+	retry             RetryPolicy
 }
 
 type PageOpts struct {
@@ -72,6 +77,38 @@ func InitialTotalCount(totalCount int) OptFunc {
 	}
 }
 
+// RetryPolicy decides which failed pages are retried and how long to wait in
+// between attempts. The wait starts at InitialBackoff and doubles for every
+// attempt up to MaxBackoff, and a random share of up to Jitter of it is skipped
+// so parallel pages don't retry all at once.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a page is tried, including the first.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is between 0 and 1.
+	Jitter float64
+	// Retryable reports whether an error is worth retrying, such as a network error.
+	Retryable func(err error) bool
+}
+
+// Retry retries pages which fail with errors the policy finds retryable, rather
+// than stopping the pagination on the first error.
+func Retry(policy RetryPolicy) OptFunc {
+	return func(p *Paginator) {
+		p.retry = policy
+	}
+}
+
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := r.InitialBackoff << (attempt - 1)
+	if backoff > r.MaxBackoff || backoff <= 0 {
+		backoff = r.MaxBackoff
+	}
+	jitter := time.Duration(rand.Float64() * r.Jitter * float64(backoff))
+	return backoff - jitter
+}
+
 var errStopPagination = errors.New("stopped")
 
 // RunSync calls the paginator function until either an error is returned, the *NextResult is nil
@@ -92,7 +129,7 @@ func (p *Paginator) run(ctx context.Context, paginate Func, parallelism int) err
 	}
 
 	// run initially once to get the total count before we start the parallel iteration
-	result, err := paginate(0, p.pageOpts(0, p.initialTotalCount), nextFunc)
+	result, err := p.paginate(ctx, paginate, 0, p.pageOpts(0, p.initialTotalCount))
 	if err != nil {
 		return fmt.Errorf("paginating: %w", err)
 	}
@@ -113,7 +150,7 @@ func (p *Paginator) run(ctx context.Context, paginate Func, parallelism int) err
 			if err := ctxhelper.Closed(ctx); err != nil {
 				return err
 			}
-			result, err := paginate(index, p.pageOpts(index, totalCount), nextFunc)
+			result, err := p.paginate(ctx, paginate, index, p.pageOpts(index, totalCount))
 			if err != nil {
 				return err
 			}
@@ -130,6 +167,26 @@ func (p *Paginator) run(ctx context.Context, paginate Func, parallelism int) err
 	return nil
 }
 
+// paginate calls paginate for the page, retrying according to the retry policy.
+func (p *Paginator) paginate(ctx context.Context, paginate Func, index int, opts PageOpts) (*NextResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := paginate(index, opts, nextFunc)
+		if err == nil || attempt >= p.retry.MaxAttempts || p.retry.Retryable == nil || !p.retry.Retryable(err) {
+			return result, err
+		}
+
+		backoff := p.retry.backoff(attempt)
+		slog.DebugContext(ctx, "retrying page", "index", index, "attempt", attempt, "backoff", backoff, slogutil.Error(err))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
 func (p *Paginator) pageOpts(i, max int) PageOpts {
 	offset := p.initialOffset + i*p.pageSize
 	limit := p.pageSize
@@ -0,0 +1,126 @@
+package paginator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func testPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Retryable: func(err error) bool {
+			return errors.Is(err, errTransient)
+		},
+	}
+}
+
+// failingPages returns a Func paginating total items which fails the first
+// failures attempts of every page with err, and records the attempts per offset.
+// The attempts mustn't be read until the pagination is done.
+func failingPages(total, failures int, err error) (Func, map[int]int) {
+	mux := &sync.Mutex{}
+	attempts := make(map[int]int)
+	return func(index int, opts PageOpts, next NextFunc) (*NextResult, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		attempts[opts.Offset]++
+		if attempts[opts.Offset] <= failures {
+			return nil, err
+		}
+		return next(total), nil
+	}, attempts
+}
+
+func TestRunRetriesTransientErrors(t *testing.T) {
+	paginate, attempts := failingPages(10, 2, errTransient)
+
+	p := New(PageSize(2), Retry(testPolicy()))
+	if err := p.Run(context.Background(), paginate); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(attempts) != 5 {
+		t.Errorf("paginated %d pages, want 5", len(attempts))
+	}
+	for offset, n := range attempts {
+		if n != 3 {
+			t.Errorf("page at offset %d was attempted %d times, want 3", offset, n)
+		}
+	}
+}
+
+func TestRunGivesUpAfterMaxAttempts(t *testing.T) {
+	paginate, attempts := failingPages(10, 3, errTransient)
+
+	p := New(PageSize(2), Retry(testPolicy()))
+	if err := p.Run(context.Background(), paginate); !errors.Is(err, errTransient) {
+		t.Fatalf("Run() error = %v, want %v", err, errTransient)
+	}
+	if attempts[0] != 3 {
+		t.Errorf("first page was attempted %d times, want 3", attempts[0])
+	}
+}
+
+func TestRunDoesNotRetryOtherErrors(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	paginate, attempts := failingPages(10, 1, errPermanent)
+
+	p := New(PageSize(2), Retry(testPolicy()))
+	if err := p.Run(context.Background(), paginate); !errors.Is(err, errPermanent) {
+		t.Fatalf("Run() error = %v, want %v", err, errPermanent)
+	}
+	if attempts[0] != 1 {
+		t.Errorf("first page was attempted %d times, want 1", attempts[0])
+	}
+}
+
+func TestRunWithoutRetryPolicy(t *testing.T) {
+	paginate, attempts := failingPages(10, 1, errTransient)
+
+	if err := New(PageSize(2)).Run(context.Background(), paginate); !errors.Is(err, errTransient) {
+		t.Fatalf("Run() error = %v, want %v", err, errTransient)
+	}
+	if attempts[0] != 1 {
+		t.Errorf("first page was attempted %d times, want 1", attempts[0])
+	}
+}
+
+func TestRunStopsRetryingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	paginate := func(index int, opts PageOpts, next NextFunc) (*NextResult, error) {
+		cancel()
+		return nil, errTransient
+	}
+
+	policy := testPolicy()
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+	err := New(Retry(policy)).Run(ctx, paginate)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, errTransient) {
+		t.Errorf("Run() error = %v, want both %v and %v", err, errTransient, context.Canceled)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := policy.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for attempt := 1; attempt <= 5; attempt++ {
+		full := RetryPolicy{InitialBackoff: policy.InitialBackoff, MaxBackoff: policy.MaxBackoff}.backoff(attempt)
+		if got := policy.backoff(attempt); got > full || got < full/2 {
+			t.Errorf("backoff(%d) with jitter = %s, want between %s and %s", attempt, got, full/2, full)
+		}
+	}
+}
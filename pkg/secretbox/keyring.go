@@ -0,0 +1,40 @@
+package secretbox
+
+import "errors"
+
+// Keyring seals with a single key and opens with any of its keys,
+// which allows keys to be rotated without invalidating existing data.
+type Keyring struct {
+	boxes []*Box
+}
+
+// NewKeyring returns a Keyring which seals with encryptKey and opens with
+// encryptKey or any of the decryptKeys.
+func NewKeyring(encryptKey []byte, decryptKeys ...[]byte) (*Keyring, error) {
+	boxes := make([]*Box, 0, len(decryptKeys)+1)
+	for _, key := range append([][]byte{encryptKey}, decryptKeys...) {
+		box, err := New(key)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+	}
+	return &Keyring{boxes: boxes}, nil
+}
+
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	return k.boxes[0].Seal(plaintext)
+}
+
+func (k *Keyring) Open(sealed []byte) ([]byte, error) {
+	for _, box := range k.boxes {
+		plaintext, err := box.Open(sealed)
+		if errors.Is(err, ErrInvalidCiphertext) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return plaintext, nil
+	}
+	return nil, ErrInvalidCiphertext
+}
@@ -0,0 +1,47 @@
+package secretbox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKeyBytes := newKey(t), newKey(t)
+
+	oldRing, err := NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	sealedWithOld, err := oldRing.Seal([]byte("old"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	rotated, err := NewKeyring(newKeyBytes, oldKey)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	opened, err := rotated.Open(sealedWithOld)
+	if err != nil {
+		t.Fatalf("opening data sealed with a decryption key: %v", err)
+	}
+	if string(opened) != "old" {
+		t.Errorf("Open() = %q, want %q", opened, "old")
+	}
+
+	sealedWithNew, err := rotated.Seal([]byte("new"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if _, err := oldRing.Open(sealedWithNew); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("data sealed after rotation opened with the old key only, error = %v", err)
+	}
+
+	newOnly, err := NewKeyring(newKeyBytes)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if _, err := newOnly.Open(sealedWithOld); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("data sealed with a dropped key opened, error = %v", err)
+	}
+}
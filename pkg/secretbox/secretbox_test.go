@@ -0,0 +1,110 @@
+package secretbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return key
+}
+
+func TestBoxSealOpen(t *testing.T) {
+	box, err := New(newKey(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	plaintext := []byte("refresh token")
+	sealed, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Errorf("sealed data contains the plaintext")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open() = %q, want %q", opened, plaintext)
+	}
+
+	again, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Errorf("sealing twice gave the same data, nonces must be random")
+	}
+}
+
+func TestBoxOpenInvalid(t *testing.T) {
+	box, err := New(newKey(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sealed, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	otherBox, err := New(newKey(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name   string
+		box    *Box
+		sealed []byte
+	}{
+		{name: "tampered", box: box, sealed: tampered},
+		{name: "truncated", box: box, sealed: sealed[:4]},
+		{name: "empty", box: box, sealed: nil},
+		{name: "other key", box: otherBox, sealed: sealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.box.Open(tt.sealed); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("Open() error = %v, want %v", err, ErrInvalidCiphertext)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidKeys(t *testing.T) {
+	for _, size := range []int{0, 16, KeySize + 1} {
+		if _, err := New(make([]byte, size)); err == nil {
+			t.Errorf("New() with a %d byte key succeeded", size)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := newKey(t)
+	parsed, err := ParseKey(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	if !bytes.Equal(parsed, key) {
+		t.Errorf("ParseKey() = %x, want %x", parsed, key)
+	}
+
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+		if _, err := ParseKey(encoded); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", encoded)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package spotifyutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/zmb3/spotify"
+)
+
+// ErrorTransport returns a RoundTripper which rewrites the bodies of rate limited
+// and failed responses which aren't Web API errors, such as the empty or HTML
+// bodies of gateway errors, into Web API errors. The spotify client otherwise
+// can't decode them, so they'd lose their status and with it whether they're
+// transient, see IsTransientError.
+func ErrorTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &errorTransport{next: next}
+}
+
+type errorTransport struct {
+	next http.RoundTripper
+}
+
+func (t *errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < http.StatusInternalServerError {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	if isWebAPIError(body) {
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		return res, nil
+	}
+
+	body, err = json.Marshal(map[string]spotify.Error{"error": {Status: res.StatusCode, Message: http.StatusText(res.StatusCode)}})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling error: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header = res.Header.Clone()
+	res.Header.Set("Content-Type", "application/json")
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return res, nil
+}
+
+func isWebAPIError(body []byte) bool {
+	var e struct {
+		Error *spotify.Error `json:"error"`
+	}
+	return json.Unmarshal(body, &e) == nil && e.Error != nil && e.Error.Status != 0
+}
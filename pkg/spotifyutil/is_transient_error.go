@@ -0,0 +1,27 @@
+package spotifyutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/zmb3/spotify"
+)
+
+// IsTransientError reports whether err is likely to go away when retried, which
+// network errors, rate limiting and server errors from Spotify are. Server errors
+// without Web API error bodies are only recognised when the client's responses
+// pass through ErrorTransport.
+func IsTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) {
+		return spotifyErr.Status == http.StatusTooManyRequests || spotifyErr.Status >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
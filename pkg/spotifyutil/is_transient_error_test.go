@@ -0,0 +1,91 @@
+package spotifyutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func respond(status int, contentType, body string) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": {contentType}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+}
+
+func TestIsTransientErrorOfClientErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		want      bool
+	}{
+		{
+			name:      "empty bad gateway",
+			transport: respond(http.StatusBadGateway, "text/plain", ""),
+			want:      true,
+		},
+		{
+			name:      "html service unavailable",
+			transport: respond(http.StatusServiceUnavailable, "text/html", "<html><body>Service Unavailable</body></html>"),
+			want:      true,
+		},
+		{
+			name:      "web api server error",
+			transport: respond(http.StatusInternalServerError, "application/json", `{"error":{"status":500,"message":"Server error"}}`),
+			want:      true,
+		},
+		{
+			name:      "rate limited",
+			transport: respond(http.StatusTooManyRequests, "text/plain", ""),
+			want:      true,
+		},
+		{
+			name:      "not found",
+			transport: respond(http.StatusNotFound, "application/json", `{"error":{"status":404,"message":"Non existing id"}}`),
+			want:      false,
+		},
+		{
+			name: "network error",
+			transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}
+			}),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := spotify.NewClient(&http.Client{Transport: ErrorTransport(tt.transport)})
+			_, err := client.GetTrack("track-1")
+			if err == nil {
+				t.Fatalf("GetTrack succeeded")
+			}
+			if got := IsTransientError(err); got != tt.want {
+				t.Errorf("IsTransientError(%v) = %t, want %t", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransientErrorOfCancellation(t *testing.T) {
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, fmt.Errorf("getting track: %w", context.Canceled)} {
+		if IsTransientError(err) {
+			t.Errorf("IsTransientError(%v) = true, want false", err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package srv
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"time"
@@ -50,7 +51,18 @@ func JSON(w http.ResponseWriter, data interface{}, opts ...ResponseOptFunc) {
 	respond(w, b, append([]ResponseOptFunc{ApplicationTypeJSON()}, opts...)...)
 }
 
+// FieldErrorer is implemented by errors that can point out which fields of a
+// request were invalid. JSONError includes them in the response as "fields".
+type FieldErrorer interface {
+	FieldErrors() map[string]string
+}
+
 func JSONError(w http.ResponseWriter, err error, opts ...ResponseOptFunc) {
+	var fe FieldErrorer
+	if errors.As(err, &fe) {
+		JSON(w, map[string]any{"error": err.Error(), "fields": fe.FieldErrors()}, opts...)
+		return
+	}
 	JSON(w, map[string]string{"error": err.Error()}, opts...)
 }
 
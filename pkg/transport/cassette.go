@@ -0,0 +1,290 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kristofferostlund/recommendli/pkg/slogutil"
+)
+
+const redacted = "REDACTED"
+
+var (
+	// scrubbedHeaders carry credentials and are never written to cassettes.
+	scrubbedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+	// scrubbedFields are the credentials in OAuth2 token requests and responses.
+	scrubbedFields = []string{"access_token", "refresh_token", "code", "client_secret", "code_verifier"}
+
+	unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+)
+
+// Interaction is a request and its response, as stored in a cassette file.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// key identifies the requests which are replayed with the same responses.
+func (r RecordedRequest) key() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%s", r.Method, r.URL, r.Body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Record returns a RoundTripper which writes every request sent through next and
+// its response to a file of its own in dir, with credentials scrubbed.
+// The files are numbered in the order the responses were received, continuing
+// after any interactions already in dir.
+func Record(next http.RoundTripper, dir string) (http.RoundTripper, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating cassette directory: %w", err)
+	}
+	files, err := cassetteFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	seq := 0
+	if len(files) > 0 {
+		seq, _ = strconv.Atoi(strings.SplitN(filepath.Base(files[len(files)-1]), "-", 2)[0])
+	}
+	return &recorder{next: next, dir: dir, seq: seq}, nil
+}
+
+type recorder struct {
+	next http.RoundTripper
+	dir  string
+
+	mux sync.Mutex
+	seq int
+}
+
+func (t *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	// The scrubbed body's length may differ, and replayed responses set their own.
+	resHeader := scrubHeader(res.Header)
+	resHeader.Del("Content-Length")
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: scrubHeader(req.Header),
+			Body:   scrubBody(req.Header.Get("Content-Type"), reqBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: res.StatusCode,
+			Header:     resHeader,
+			Body:       scrubBody(res.Header.Get("Content-Type"), resBody),
+		},
+	}
+	// Failing to record shouldn't fail the request.
+	if err := t.write(interaction); err != nil {
+		slog.ErrorContext(req.Context(), "Could not record interaction", slog.String("url", interaction.Request.URL), slogutil.Error(err))
+	}
+	return res, nil
+}
+
+func (t *recorder) write(interaction Interaction) error {
+	b, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling interaction: %w", err)
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.seq++
+	name := fmt.Sprintf("%06d-%s-%s.json", t.seq, interaction.Request.Method, strings.Trim(unsafeFileChars.ReplaceAllString(interaction.Request.URL, "_"), "_"))
+	if len(name) > 200 {
+		name = name[:195] + ".json"
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, name), b, 0o600); err != nil {
+		return fmt.Errorf("writing interaction: %w", err)
+	}
+	return nil
+}
+
+// Replay returns a RoundTripper which responds with the interactions recorded to
+// dir by Record, without sending any requests. Requests with the same method,
+// URL and body get the responses recorded for them in order, and the last one
+// once they run out. Requests which weren't recorded fail.
+func Replay(dir string) (http.RoundTripper, error) {
+	files, err := cassetteFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	interactions := make(map[string][]Interaction)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading interaction: %w", err)
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(b, &interaction); err != nil {
+			return nil, fmt.Errorf("parsing interaction %s: %w", filepath.Base(file), err)
+		}
+		key := interaction.Request.key()
+		interactions[key] = append(interactions[key], interaction)
+	}
+	return &replayer{interactions: interactions, replayed: make(map[string]int)}, nil
+}
+
+type replayer struct {
+	mux          sync.Mutex
+	interactions map[string][]Interaction
+	replayed     map[string]int
+}
+
+func (t *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Body:   scrubBody(req.Header.Get("Content-Type"), body),
+	}.key()
+
+	t.mux.Lock()
+	recorded := t.interactions[key]
+	n := t.replayed[key]
+	t.replayed[key]++
+	t.mux.Unlock()
+
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	res := recorded[min(n, len(recorded)-1)].Response
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)),
+		StatusCode:    res.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        res.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(res.Body)),
+		ContentLength: int64(len(res.Body)),
+		Request:       req,
+	}, nil
+}
+
+func cassetteFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing cassette directory: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readRequestBody reads the body of req, returning a copy of req whose body can
+// still be read as RoundTrippers mustn't modify requests.
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading request body: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req, body, nil
+}
+
+func scrubHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range scrubbedHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+	return header
+}
+
+// scrubBody redacts the credentials in form encoded and JSON bodies.
+func scrubBody(contentType string, body []byte) string {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return string(body)
+		}
+		for _, field := range scrubbedFields {
+			if values.Has(field) {
+				values.Set(field, redacted)
+			}
+		}
+		return values.Encode()
+	case strings.HasPrefix(contentType, "application/json"):
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return string(body)
+		}
+		scrubbed := false
+		for _, field := range scrubbedFields {
+			if _, ok := fields[field]; ok {
+				fields[field] = json.RawMessage(`"` + redacted + `"`)
+				scrubbed = true
+			}
+		}
+		if !scrubbed {
+			return string(body)
+		}
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return string(body)
+		}
+		return string(b)
+	default:
+		return string(body)
+	}
+}
@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecordScrubsCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=leaked-session")
+		fmt.Fprint(w, `{"access_token":"leaked-access","refresh_token":"leaked-refresh","token_type":"Bearer"}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := Record(http.DefaultTransport, dir)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	client := &http.Client{Transport: recorder}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"leaked-code"},
+		"client_secret": {"leaked-client"},
+	}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Basic leaked-basic")
+	req.Header.Set("Cookie", "session=leaked-session")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if !strings.Contains(string(body), "leaked-access") {
+		t.Errorf("the caller got a scrubbed response: %s", body)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got cassette files %v (%v), want one", files, err)
+	}
+	recorded, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("reading cassette: %v", err)
+	}
+	if strings.Contains(string(recorded), "leaked") {
+		t.Errorf("cassette contains credentials:\n%s", recorded)
+	}
+	for _, want := range []string{"authorization_code", "token_type", redacted} {
+		if !strings.Contains(string(recorded), want) {
+			t.Errorf("cassette lacks %q:\n%s", want, recorded)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "response %d to %s", calls.Add(1), r.URL.Path)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := Record(http.DefaultTransport, dir)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	for _, path := range []string{"/a", "/a", "/b"} {
+		get(t, &http.Client{Transport: recorder}, server.URL+path)
+	}
+
+	replayer, err := Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	client := &http.Client{Transport: replayer}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/b", want: "response 3 to /b"},
+		{path: "/a", want: "response 1 to /a"},
+		{path: "/a", want: "response 2 to /a"},
+		// The last response is repeated once they run out.
+		{path: "/a", want: "response 2 to /a"},
+	}
+	for _, tt := range tests {
+		if got := get(t, client, server.URL+tt.path); got != tt.want {
+			t.Errorf("GET %s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Errorf("server got %d requests, replaying mustn't send any", calls.Load())
+	}
+
+	if _, err := client.Get(server.URL + "/not-recorded"); err == nil {
+		t.Errorf("replaying a request which wasn't recorded succeeded")
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading response of %s: %v", url, err)
+	}
+	return string(body)
+}
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// WaitRateLimit is the reason of waits for the rate limit.
+	WaitRateLimit = "rate_limit"
+	// WaitRetryAfter is the reason of waits for a Retry-After of an earlier response.
+	WaitRetryAfter = "retry_after"
+)
+
+// RateLimiter limits the rate of requests per key, such as per user, with a
+// token bucket per key. Once a response tells a key to retry after a while, its
+// requests are held until then.
+type RateLimiter struct {
+	rps   float64
+	burst int
+	waits prometheus.ObserverVec
+
+	mux     sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rps requests per second per key,
+// with bursts of up to burst requests. The time every request waited is observed
+// in waits, labelled with its reason, when it's set.
+func NewRateLimiter(rps float64, burst int, waits prometheus.ObserverVec) *RateLimiter {
+	return &RateLimiter{
+		rps:     rps,
+		burst:   max(burst, 1),
+		waits:   waits,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Transport returns a RoundTripper which sends the requests of key through next,
+// waiting for the rate limit first.
+func (l *RateLimiter) Transport(key string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &rateLimitTransport{limiter: l, key: key, next: next}
+}
+
+type rateLimitTransport struct {
+	limiter *RateLimiter
+	key     string
+	next    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.wait(req); err != nil {
+		return nil, err
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			t.limiter.block(t.key, time.Now().Add(retryAfter))
+		}
+	}
+	return res, nil
+}
+
+// wait waits for the rate limit, and then for as long as the key is blocked as
+// requests which were already waiting may have been told to retry after a while.
+func (t *rateLimitTransport) wait(req *http.Request) error {
+	start := time.Now()
+	wait, reason := t.limiter.reserve(t.key, start)
+	for wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for rate limit: %w", req.Context().Err())
+		case <-timer.C:
+		}
+		if wait = t.limiter.blocked(t.key, time.Now()); wait > 0 {
+			reason = WaitRetryAfter
+		}
+	}
+	if t.limiter.waits != nil {
+		t.limiter.waits.WithLabelValues(reason).Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
+
+// reserve takes a token from the key's bucket, returning how long to wait before
+// sending the request and why.
+func (l *RateLimiter) reserve(key string, now time.Time) (time.Duration, string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	b.tokens--
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / l.rps * float64(time.Second))
+	}
+	if blocked := b.blockedUntil.Sub(now); blocked > wait {
+		return blocked, WaitRetryAfter
+	}
+	return wait, WaitRateLimit
+}
+
+// blocked returns how long the key is blocked for.
+func (l *RateLimiter) blocked(key string, now time.Time) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		return max(b.blockedUntil.Sub(now), 0)
+	}
+	return 0
+}
+
+func (l *RateLimiter) block(key string, until time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if b, ok := l.buckets[key]; ok && until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in either seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(2, 3, nil)
+	now := time.Now()
+
+	// The burst is let through at once, after which requests are spaced out.
+	for i := 0; i < 3; i++ {
+		if wait, _ := l.reserve("user", now); wait != 0 {
+			t.Fatalf("request %d of the burst waited %s", i+1, wait)
+		}
+	}
+	if wait, reason := l.reserve("user", now); wait != 500*time.Millisecond || reason != WaitRateLimit {
+		t.Errorf("reserve() = %s, %s, want 500ms, %s", wait, reason, WaitRateLimit)
+	}
+	if wait, _ := l.reserve("user", now); wait != time.Second {
+		t.Errorf("reserve() = %s, want 1s", wait)
+	}
+
+	// Keys have buckets of their own.
+	if wait, _ := l.reserve("other user", now); wait != 0 {
+		t.Errorf("another key waited %s", wait)
+	}
+
+	// Tokens are refilled over time.
+	if wait, _ := l.reserve("user", now.Add(5*time.Second)); wait != 0 {
+		t.Errorf("reserve() after the bucket refilled waited %s", wait)
+	}
+}
+
+func TestRateLimiterBlock(t *testing.T) {
+	l := NewRateLimiter(100, 10, nil)
+	now := time.Now()
+	l.reserve("user", now)
+
+	l.block("user", now.Add(3*time.Second))
+	// An earlier Retry-After doesn't shorten the block.
+	l.block("user", now.Add(time.Second))
+
+	if wait, reason := l.reserve("user", now); wait != 3*time.Second || reason != WaitRetryAfter {
+		t.Errorf("reserve() = %s, %s, want 3s, %s", wait, reason, WaitRetryAfter)
+	}
+	if blocked := l.blocked("user", now.Add(2*time.Second)); blocked != time.Second {
+		t.Errorf("blocked() = %s, want 1s", blocked)
+	}
+	if blocked := l.blocked("user", now.Add(4*time.Second)); blocked != 0 {
+		t.Errorf("blocked() after the block = %s, want 0", blocked)
+	}
+	if blocked := l.blocked("other user", now); blocked != 0 {
+		t.Errorf("another key is blocked for %s", blocked)
+	}
+}
+
+func TestRateLimiterTransportHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	l := NewRateLimiter(100, 10, nil)
+	client := &http.Client{Transport: l.Transport("user", http.DefaultTransport)}
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+
+	start := time.Now()
+	res, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	res.Body.Close()
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Errorf("request after a Retry-After of 1s waited %s", waited)
+	}
+
+	other := &http.Client{Transport: l.Transport("other user", http.DefaultTransport)}
+	start = time.Now()
+	res, err = other.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	res.Body.Close()
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("another key waited %s for the Retry-After", waited)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "3", want: 3 * time.Second, wantOK: true},
+		{value: "-1", want: 0, wantOK: true},
+		{value: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute, wantOK: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %t, want %s, %t", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Rebase returns a RoundTripper which sends requests for URLs under from to the
+// same path under to instead, e.g. https://api.spotify.com/v1/me to
+// http://127.0.0.1:9090/v1/me when from is https://api.spotify.com/v1/ and to is
+// http://127.0.0.1:9090/v1/. Other requests are passed on to next untouched.
+func Rebase(next http.RoundTripper, from, to url.URL) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	// URL.JoinPath leaves out the leading slash when the URL has no path.
+	if !strings.HasPrefix(to.Path, "/") {
+		to.Path = "/" + to.Path
+	}
+	return &rebaseTransport{next: next, from: from, to: to}
+}
+
+type rebaseTransport struct {
+	next     http.RoundTripper
+	from, to url.URL
+}
+
+func (t *rebaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != t.from.Scheme || req.URL.Host != t.from.Host || !strings.HasPrefix(req.URL.Path, t.from.Path) {
+		return t.next.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request.
+	rebased := req.Clone(req.Context())
+	rebased.URL.Scheme = t.to.Scheme
+	rebased.URL.Host = t.to.Host
+	rebased.URL.Path = t.to.Path + strings.TrimPrefix(req.URL.Path, t.from.Path)
+	rebased.URL.RawPath = ""
+	rebased.Host = t.to.Host
+	return t.next.RoundTrip(rebased)
+}
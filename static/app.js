@@ -11,7 +11,11 @@ import DiscoveryPlaylist from './components/discovery-playlist/discovery-playlis
 import StateDump from './components/state-dump/state-dump.component.js'
 
 import { generateDiscoveryPlaylistAsync, getIndexSummaryAsync } from './store/generate/generate.actions.js'
-import { selectDiscoveryIsLoading, selectDiscoveryPlaylist } from './store/generate/generate.selectors.js'
+import {
+  selectDiscoveryIsLoading,
+  selectDiscoveryPlaylist,
+  selectDiscoveryProgress,
+} from './store/generate/generate.selectors.js'
 import PlayingContainer from './components/playing/playing.container.js'
 import IndexSummaryContainer from './components/index-summary/index-summary.container.js'
 
@@ -28,6 +32,7 @@ const App = () => {
 
   const discoveryIsLoading = selectDiscoveryIsLoading(state)
   const discoveryPlaylist = selectDiscoveryPlaylist(state)
+  const discoveryProgress = selectDiscoveryProgress(state)
 
   useEffect(() => {
     if (currentUser == null && state.user.fetchState.state === states.new) {
@@ -48,6 +53,7 @@ const App = () => {
         <${DiscoveryPlaylist}
           onGeneratePlaylist=${onGeneratePlaylist}
           isLoading=${discoveryIsLoading}
+          progress=${discoveryProgress}
           playlist=${discoveryPlaylist}
         />
         <${PlayingContainer} />
@@ -6,6 +6,7 @@ import { LinkableArtists, SpotifyLinkable } from '../linkable/linkable-component
  * @typedef {import('../../recommendli/client').Album} Album
  * @typedef {import('../../recommendli/client').Track} Track
  * @typedef {import('../../recommendli/client').Playlist} Playlist
+ * @typedef {import('../../recommendli/client').Progress} Progress
  */
 
 /**
@@ -40,14 +41,43 @@ const TrackTable = ({ tracks }) => {
   `
 }
 
+const stageNames = {
+  queued: 'Queued',
+  syncing_index: 'Syncing track index',
+  populating_playlists: 'Fetching playlists',
+  fetching_playlist_tracks: 'Fetching playlist tracks',
+  scoring: 'Scoring tracks',
+  writing_playlist: 'Writing playlist',
+  done: 'Done',
+  failed: 'Failed',
+}
+
+/**
+ * @param {{ progress: Progress }} args
+ * @returns
+ */
+const DiscoveryProgress = ({ progress }) => {
+  const stage = stageNames[progress.stage] || progress.stage
+  const counts = progress.total > 0 ? ` (${progress.current}/${progress.total})` : ''
+  return html`
+    <p>
+      <small>${stage}${progress.detail ? `: ${progress.detail}` : ''}${counts}</small>
+      ${progress.total > 0
+        ? html`<progress value=${progress.current} max=${progress.total} />`
+        : html`<progress />`}
+    </p>
+  `
+}
+
 /**
  * @param {object} args
  * @param {() => void} args.onGeneratePlaylist
  * @param {boolean} args.isLoading
+ * @param {Progress | null} args.progress
  * @param {Playlist} args.playlist
  * @returns
  */
-const DiscoveryPlaylist = ({ onGeneratePlaylist, isLoading, playlist }) => {
+const DiscoveryPlaylist = ({ onGeneratePlaylist, isLoading, progress, playlist }) => {
   const onClick = () => {
     if (onGeneratePlaylist) {
       onGeneratePlaylist()
@@ -60,6 +90,7 @@ const DiscoveryPlaylist = ({ onGeneratePlaylist, isLoading, playlist }) => {
       <button aria-busy=${isLoading} disabled=${isLoading} onClick=${onClick}>
         Generate discover playlist
       </button>
+      ${isLoading && progress != null ? html`<${DiscoveryProgress} progress=${progress} />` : null}
       ${playlist == null
         ? null
         : html`
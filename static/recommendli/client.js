@@ -36,12 +36,109 @@ import { throwOn404, redirectingFetch } from './redirecting-fetch.js'
  * @property {string} name
  * @property {ExternalUrls} external_urls
  *
+ * @typedef DiscoveryJob
+ * @property {string} id
+ * @property {string} state
+ * @property {string} [error]
+ * @property {{ tracks: { items: { track: Track }[] } } & Playlist} [playlist]
+ *
+ * @typedef Progress
+ * @property {string} stage
+ * @property {string} [detail]
+ * @property {number} current
+ * @property {number} total
+ *
  * @typedef IndexSummary
  * @property {number} playlist_count
  * @property {number} unique_track_count
  * @property {SimplePlaylist[]} playlists
  */
 
+const jobPollInterval = 2000
+
+/**
+ * @param {number} ms
+ */
+const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms))
+
+/**
+ * @param {DiscoveryJob} job
+ */
+const isFinished = (job) => job.state === 'done' || job.state === 'failed'
+
+/**
+ * @param {string} id
+ * @returns {Promise<DiscoveryJob>}
+ */
+const getDiscoveryJob = async (id) => {
+  const response = await throwOn404(redirectingFetch(`/recommendations/v1/discovery-jobs/${id}`))
+  return await response.json()
+}
+
+/**
+ * @param {DiscoveryJob} job
+ * @returns {Promise<DiscoveryJob>}
+ */
+const pollDiscoveryJob = async (job) => {
+  while (!isFinished(job)) {
+    await sleep(jobPollInterval)
+    job = await getDiscoveryJob(job.id)
+  }
+  return job
+}
+
+/**
+ * Follows the job's progress events until it's finished, falling back to polling
+ * the job if the events can't be streamed.
+ *
+ * @param {DiscoveryJob} job
+ * @param {(progress: Progress) => void} onProgress
+ * @returns {Promise<DiscoveryJob>}
+ */
+const followDiscoveryJob = (job, onProgress) => {
+  if (typeof EventSource === 'undefined') {
+    return pollDiscoveryJob(job)
+  }
+
+  return new Promise((resolve, reject) => {
+    const events = new EventSource(`/recommendations/v1/events?job=${encodeURIComponent(job.id)}`)
+    let done = false
+    const finish = (/** @type {Promise<DiscoveryJob>} */ promise) => {
+      if (done) return
+      done = true
+      events.close()
+      promise.then(resolve, reject)
+    }
+
+    events.addEventListener('progress', (e) => {
+      /** @type {Progress} */
+      const progress = JSON.parse(e.data)
+      onProgress(progress)
+      if (progress.stage === 'done' || progress.stage === 'failed') {
+        finish(getDiscoveryJob(job.id))
+      }
+    })
+    // Events published before the stream was (re)opened are missed, such as the
+    // job finishing, so the job is checked whenever it opens.
+    events.addEventListener('open', async () => {
+      try {
+        const current = await getDiscoveryJob(job.id)
+        if (isFinished(current)) {
+          finish(Promise.resolve(current))
+        }
+      } catch (error) {
+        finish(Promise.reject(error))
+      }
+    })
+    events.addEventListener('error', () => {
+      // EventSource reconnects by itself unless the stream was refused.
+      if (events.readyState === EventSource.CLOSED) {
+        finish(pollDiscoveryJob(job))
+      }
+    })
+  })
+}
+
 const recommendliClient = {
   /**
    * @returns {Promise<{ isPlaying: boolean, track?: Track }>}
@@ -59,16 +156,24 @@ const recommendliClient = {
     return await response.json()
   },
   /**
+   * Generates the playlist in a discovery job, which isn't bound by how long
+   * requests may take, and waits for it to finish. onProgress is called with
+   * every progress event of the job.
+   *
+   * @param {{ dryRun?: boolean, onProgress?: (progress: Progress) => void }} [options]
    * @returns {Promise<Playlist>}
    */
-  generateDiscoveryPlaylist: async ({ dryRun = false } = {}) => {
+  generateDiscoveryPlaylist: async ({ dryRun = false, onProgress = () => {} } = {}) => {
     const response = await throwOn404(
-      redirectingFetch(`/recommendations/v1/generate-discovery-playlist?dryrun=${dryRun || false}`)
+      redirectingFetch(`/recommendations/v1/discovery-jobs?dryrun=${dryRun || false}`, { method: 'POST' })
     )
-    const fullPlaylist = await response.json()
+    const job = await followDiscoveryJob(await response.json(), onProgress)
+    if (job.state === 'failed') {
+      throw new Error(`Generating discovery playlist failed: ${job.error}`)
+    }
     return {
-      ...fullPlaylist,
-      tracks: fullPlaylist.tracks.items.map((item) => item.track),
+      ...job.playlist,
+      tracks: job.playlist.tracks.items.map((item) => item.track),
     }
   },
   /**
@@ -3,6 +3,7 @@ import recommendliClient from '../../recommendli/client.js'
 
 export const types = {
   SET_DISCOVERY_PLAYLIST: 'SET_DISCOVERY_PLAYLIST',
+  SET_DISCOVERY_PROGRESS: 'SET_DISCOVERY_PROGRESS',
   SET_DISCOVERY_FETCH_STATE: 'SET_DISCOVERY_PLAYLIST_FETCH_STATE',
   SET_INDEX_FETCH_STATE: 'SET_INDEX_FETCH_STATE',
   SET_SUMMARY_INDEX: 'SET_SUMMARY_INDEX',
@@ -13,6 +14,14 @@ const setDiscoveryPlaylist = (playlist) => ({
   payload: playlist,
 })
 
+/**
+ * @param {import('../../recommendli/client.js').Progress | null} progress
+ */
+const setDiscoveryProgress = (progress) => ({
+  type: types.SET_DISCOVERY_PROGRESS,
+  payload: progress,
+})
+
 const setIndexSummary = (index) => ({
   type: types.SET_SUMMARY_INDEX,
   payload: index,
@@ -23,8 +32,16 @@ const setIndexFetchState = createSetFetchState(types.SET_INDEX_FETCH_STATE)
 
 export const generateDiscoveryPlaylistAsync = ({ dryRun = false } = {}) => {
   return withFetchState(setDiscoveryFetchState, async (dispatch) => {
-    const playlist = await recommendliClient.generateDiscoveryPlaylist({ dryRun })
-    dispatch(setDiscoveryPlaylist(playlist))
+    dispatch(setDiscoveryProgress(null))
+    try {
+      const playlist = await recommendliClient.generateDiscoveryPlaylist({
+        dryRun,
+        onProgress: (progress) => dispatch(setDiscoveryProgress(progress)),
+      })
+      dispatch(setDiscoveryPlaylist(playlist))
+    } finally {
+      dispatch(setDiscoveryProgress(null))
+    }
   })
 }
 
@@ -4,6 +4,8 @@ import { types } from './generate.actions.js'
 export const initialState = {
   discovery: {
     playlist: null,
+    /** @type {import('../../recommendli/client.js').Progress | null} */
+    progress: null,
     fetchState: defaultFetchState(),
   },
   indexSummary: {
@@ -22,6 +24,11 @@ export const reducer = (state = initialState, { type, payload }) => {
         ...state,
         discovery: { ...state.discovery, playlist: payload },
       }
+    case types.SET_DISCOVERY_PROGRESS:
+      return {
+        ...state,
+        discovery: { ...state.discovery, progress: payload },
+      }
     case types.SET_DISCOVERY_FETCH_STATE:
       return {
         ...state,
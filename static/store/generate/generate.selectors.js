@@ -20,3 +20,5 @@ export const selectDiscoveryIsLoading = createSelector(
 )
 
 export const selectDiscoveryPlaylist = createSelector([selectDiscovery], (discovery) => discovery.playlist)
+
+export const selectDiscoveryProgress = createSelector([selectDiscovery], (discovery) => discovery.progress)